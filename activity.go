@@ -0,0 +1,121 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Activity log formats
+const (
+	ActivityFormatCSV   = "csv"
+	ActivityFormatJSONL = "jsonl"
+)
+
+// activityRecord describes a single received transmission
+type activityRecord struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	SRC      string    `json:"src"`
+	DST      string    `json:"dst"`
+	StreamID uint16    `json:"stream_id"`
+	Frames   int       `json:"frames"`
+	Module   string    `json:"module"`
+}
+
+// activityLog appends transmission records to a file
+type activityLog struct {
+	file   *os.File
+	buf    *bufio.Writer
+	csv    *csv.Writer
+	format string
+}
+
+// newActivityLog opens the activity log at path for appending
+func newActivityLog(path, format string) (*activityLog, error) {
+	if format != ActivityFormatCSV && format != ActivityFormatJSONL {
+		return nil, fmt.Errorf("unsupported activity log format: %s", format)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open activity log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat activity log: %w", err)
+	}
+
+	l := &activityLog{
+		file:   file,
+		buf:    bufio.NewWriter(file),
+		format: format,
+	}
+	if format == ActivityFormatCSV {
+		l.csv = csv.NewWriter(l.buf)
+		// Write the header when starting a new file
+		if info.Size() == 0 {
+			l.csv.Write([]string{"start", "end", "src", "dst", "stream_id", "frames", "module"})
+		}
+	}
+
+	return l, nil
+}
+
+// write buffers a transmission record
+func (l *activityLog) write(rec activityRecord) error {
+	if l.format == ActivityFormatCSV {
+		return l.csv.Write([]string{
+			rec.Start.Format(time.RFC3339),
+			rec.End.Format(time.RFC3339),
+			rec.SRC,
+			rec.DST,
+			formatHex(rec.StreamID),
+			strconv.Itoa(rec.Frames),
+			rec.Module,
+		})
+	}
+	return json.NewEncoder(l.buf).Encode(rec)
+}
+
+// flush writes buffered records to the file
+func (l *activityLog) flush() error {
+	if l.csv != nil {
+		l.csv.Flush()
+		if err := l.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return l.buf.Flush()
+}
+
+// Close flushes and closes the activity log
+func (l *activityLog) Close() error {
+	if err := l.flush(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
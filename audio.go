@@ -0,0 +1,244 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go-m17-listen/codec2"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/hajimehoshi/oto"
+)
+
+// Audio backends
+const (
+	AudioBackendOto   = "oto"
+	AudioBackendPulse = "pulse"
+	AudioBackendALSA  = "alsa"
+)
+
+// Audio sample rates in Hz. Codec 2 decodes to codecSampleRate in every
+// mode, the output can be resampled to a higher rate up to maxOutputRate.
+const (
+	codecSampleRate = codec2.SampleRate
+	maxOutputRate   = 192000
+)
+
+// Player plays raw 16-bit little-endian mono PCM audio
+type Player interface {
+	Write(p []byte) (int, error)
+	Close() error
+}
+
+// newPlayer creates a player using the given audio backend playing at rate Hz.
+// bufferSize is in bytes at 8kHz and is scaled with the rate so the latency
+// stays the same.
+func newPlayer(backend string, rate, bufferSize int) (Player, error) {
+	bufferSize = bufferSize * rate / codecSampleRate
+	switch backend {
+	case AudioBackendOto, "":
+		return newOtoPlayer(rate, bufferSize)
+	case AudioBackendPulse:
+		return newCommandPlayer("pacat", "--playback", "--raw", "--format=s16le", fmt.Sprintf("--rate=%d", rate), "--channels=1",
+			"--client-name=go-m17-listen", fmt.Sprintf("--latency=%d", bufferSize))
+	case AudioBackendALSA:
+		return newCommandPlayer("aplay", "-q", "-t", "raw", "-f", "S16_LE", "-r", fmt.Sprint(rate), "-c", "1",
+			fmt.Sprintf("--buffer-size=%d", bufferSize/2))
+	default:
+		return nil, fmt.Errorf("unknown audio backend: %s", backend)
+	}
+}
+
+// otoPlayer plays audio with Oto
+type otoPlayer struct {
+	*oto.Player
+	ctx *oto.Context
+}
+
+// newOtoPlayer creates an Oto context and player
+func newOtoPlayer(rate, bufferSize int) (*otoPlayer, error) {
+	ctx, err := oto.NewContext(rate, 1, 2, bufferSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Oto context: %w", err)
+	}
+	return &otoPlayer{Player: ctx.NewPlayer(), ctx: ctx}, nil
+}
+
+// Close closes the player and its context
+func (p *otoPlayer) Close() error {
+	err := p.Player.Close()
+	if cerr := p.ctx.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// commandPlayer plays audio by piping it to an external command such as
+// pacat or aplay
+type commandPlayer struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+// newCommandPlayer starts the command that audio is piped to
+func newCommandPlayer(name string, args ...string) (*commandPlayer, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", name, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", name, err)
+	}
+	return &commandPlayer{cmd: cmd, stdin: stdin}, nil
+}
+
+// Write pipes audio to the command
+func (p *commandPlayer) Write(buf []byte) (int, error) {
+	return p.stdin.Write(buf)
+}
+
+// Close closes the pipe and waits for the command to finish playing
+func (p *commandPlayer) Close() error {
+	if err := p.stdin.Close(); err != nil {
+		return err
+	}
+	return p.cmd.Wait()
+}
+
+// writerPlayer writes audio to a writer such as stdout
+type writerPlayer struct {
+	io.Writer
+}
+
+// Close does nothing, the writer is owned by the caller
+func (writerPlayer) Close() error {
+	return nil
+}
+
+// fifoWriteTimeout is how long a write to the FIFO may wait for the reader to
+// catch up before the audio is dropped
+const fifoWriteTimeout = 10 * time.Millisecond
+
+// fifoPlayer writes audio to a named pipe for another long running process
+// to consume. Audio is dropped while no reader has the pipe open or the
+// reader falls behind, so the reader can come and go without stalling the
+// client.
+type fifoPlayer struct {
+	path string
+	f    *os.File
+}
+
+// newFIFOPlayer creates the named pipe at path with mkfifo unless it exists
+func newFIFOPlayer(path string) (*fifoPlayer, error) {
+	info, err := os.Stat(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if out, err := exec.Command("mkfifo", path).CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("failed to create FIFO %s: %w: %s", path, err, bytes.TrimSpace(out))
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to open FIFO: %w", err)
+	case info.Mode()&os.ModeNamedPipe == 0:
+		return nil, fmt.Errorf("%s exists and is not a FIFO", path)
+	}
+	return &fifoPlayer{path: path}, nil
+}
+
+// Write writes audio to the FIFO, opening it once a reader appears
+func (p *fifoPlayer) Write(buf []byte) (int, error) {
+	if p.f == nil {
+		// Opening without blocking fails until there is a reader
+		f, err := os.OpenFile(p.path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+		if errors.Is(err, syscall.ENXIO) {
+			return len(buf), nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		slog.Info("FIFO reader connected", "path", p.path)
+		p.f = f
+	}
+
+	if err := p.f.SetWriteDeadline(time.Now().Add(fifoWriteTimeout)); err != nil {
+		return 0, err
+	}
+	n, err := p.f.Write(buf)
+	switch {
+	case errors.Is(err, syscall.EPIPE):
+		slog.Info("FIFO reader disconnected", "path", p.path)
+		p.f.Close()
+		p.f = nil
+		return len(buf), nil
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return len(buf), nil
+	}
+	return n, err
+}
+
+// Close closes the FIFO, leaving the named pipe in place for the next run
+func (p *fifoPlayer) Close() error {
+	if p.f == nil {
+		return nil
+	}
+	return p.f.Close()
+}
+
+// resampler upsamples 8kHz audio to the output rate by linear interpolation.
+// The last sample of each frame is carried over to the next so there are no
+// clicks at frame boundaries.
+type resampler struct {
+	step float64 // Input samples per output sample
+	pos  float64 // Position of the next output sample, 0 is the last sample
+	last int16   // Last sample of the previous frame
+}
+
+// newResampler creates a resampler from codecSampleRate to rate
+func newResampler(rate int) *resampler {
+	return &resampler{step: float64(codecSampleRate) / float64(rate)}
+}
+
+// resample returns the audio at the output rate
+func (r *resampler) resample(audio []int16) []int16 {
+	out := make([]int16, 0, int(float64(len(audio))/r.step)+1)
+	for r.pos < float64(len(audio)) {
+		i := int(r.pos)
+		frac := r.pos - float64(i)
+		a := r.last
+		if i > 0 {
+			a = audio[i-1]
+		}
+		b := audio[i]
+		out = append(out, int16(math.Round(float64(a)+(float64(b)-float64(a))*frac)))
+		r.pos += r.step
+	}
+	r.pos -= float64(len(audio))
+	if len(audio) > 0 {
+		r.last = audio[len(audio)-1]
+	}
+	return out
+}
@@ -0,0 +1,375 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go-m17-listen/m17"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// captureMagic starts a capture file. Each record that follows is an 8-byte
+// big-endian Unix time in nanoseconds, a 2-byte big-endian payload length and
+// the UDP payload.
+const captureMagic = "M17CAP01"
+
+// pcap file magic numbers as read in little-endian order
+const (
+	pcapMagic            = 0xA1B2C3D4
+	pcapMagicSwapped     = 0xD4C3B2A1
+	pcapMagicNano        = 0xA1B23C4D
+	pcapMagicNanoSwapped = 0x4D3CB2A1
+)
+
+// pcap link types
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLinuxSLL = 113
+)
+
+// pcapMaxRecord is the largest pcap record read, whatever the file header
+// claims, so a corrupt or hostile file can't make us allocate gigabytes
+const pcapMaxRecord = 256 * 1024
+
+// captureWriter records received UDP payloads to a capture file. A nil
+// *captureWriter discards packets.
+type captureWriter struct {
+	file *os.File
+	w    *bufio.Writer
+}
+
+// newCaptureWriter creates the capture file at path, replacing any existing
+// file
+func newCaptureWriter(path string) (*captureWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create capture file: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	if _, err := w.WriteString(captureMagic); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write capture file: %w", err)
+	}
+	return &captureWriter{file: f, w: w}, nil
+}
+
+// write records a packet, flushing it so the capture survives a crash
+func (cw *captureWriter) write(packet []byte) error {
+	if cw == nil {
+		return nil
+	}
+	var header [10]byte
+	binary.BigEndian.PutUint64(header[0:8], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint16(header[8:10], uint16(len(packet)))
+	if _, err := cw.w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := cw.w.Write(packet); err != nil {
+		return err
+	}
+	return cw.w.Flush()
+}
+
+// Close flushes and closes the capture file
+func (cw *captureWriter) Close() error {
+	if cw == nil {
+		return nil
+	}
+	if err := cw.w.Flush(); err != nil {
+		cw.file.Close()
+		return err
+	}
+	return cw.file.Close()
+}
+
+// readCapture calls fn with the arrival time and payload of each UDP packet
+// in a capture file or a pcap file, stopping early if fn returns an error. A
+// missing arrival time is passed as the zero time.
+func readCapture(path string, fn func(time.Time, []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open capture file: %w", err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	magic, err := r.Peek(len(captureMagic))
+	if err != nil {
+		return fmt.Errorf("failed to read capture file: %w", err)
+	}
+	if string(magic) == captureMagic {
+		r.Discard(len(captureMagic))
+		return readCaptureRecords(r, fn)
+	}
+	switch binary.LittleEndian.Uint32(magic) {
+	case pcapMagic:
+		return readPcap(r, binary.LittleEndian, false, fn)
+	case pcapMagicNano:
+		return readPcap(r, binary.LittleEndian, true, fn)
+	case pcapMagicSwapped:
+		return readPcap(r, binary.BigEndian, false, fn)
+	case pcapMagicNanoSwapped:
+		return readPcap(r, binary.BigEndian, true, fn)
+	}
+	return fmt.Errorf("%s is not a capture or pcap file", path)
+}
+
+// readCaptureRecords reads the records of a capture file
+func readCaptureRecords(r io.Reader, fn func(time.Time, []byte) error) error {
+	var header [10]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to read capture file: %w", err)
+		}
+		packet := make([]byte, binary.BigEndian.Uint16(header[8:10]))
+		if _, err := io.ReadFull(r, packet); err != nil {
+			return fmt.Errorf("failed to read capture file: %w", err)
+		}
+		var at time.Time
+		if ns := binary.BigEndian.Uint64(header[0:8]); ns != 0 {
+			at = time.Unix(0, int64(ns))
+		}
+		if err := fn(at, packet); err != nil {
+			return err
+		}
+	}
+}
+
+// readPcap reads the UDP payloads of a pcap file, whose timestamps have
+// nanosecond rather than microsecond resolution when nano is set
+func readPcap(r io.Reader, order binary.ByteOrder, nano bool, fn func(time.Time, []byte) error) error {
+	var header [24]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return fmt.Errorf("failed to read pcap file: %w", err)
+	}
+	// Records are never longer than the snapshot length, which some
+	// writers leave as zero
+	maxRecord := uint32(pcapMaxRecord)
+	if snapLen := order.Uint32(header[16:20]); snapLen != 0 {
+		maxRecord = min(maxRecord, snapLen)
+	}
+	linkType := order.Uint32(header[20:24]) & 0xFFFF
+	switch linkType {
+	case linkTypeNull, linkTypeEthernet, linkTypeRaw, linkTypeLinuxSLL:
+	default:
+		return fmt.Errorf("unsupported pcap link type: %d", linkType)
+	}
+
+	var record [16]byte
+	for {
+		if _, err := io.ReadFull(r, record[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to read pcap file: %w", err)
+		}
+		length := order.Uint32(record[8:12])
+		if length > maxRecord {
+			return fmt.Errorf("pcap record of %d bytes is longer than the %d byte limit", length, maxRecord)
+		}
+		frame := make([]byte, length)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return fmt.Errorf("failed to read pcap file: %w", err)
+		}
+		if payload, ok := udpPayload(linkType, frame); ok {
+			var at time.Time
+			sec, frac := int64(order.Uint32(record[0:4])), int64(order.Uint32(record[4:8]))
+			if sec != 0 || frac != 0 {
+				if !nano {
+					frac *= int64(time.Microsecond)
+				}
+				at = time.Unix(sec, frac)
+			}
+			if err := fn(at, payload); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// udpPayload extracts the UDP payload from a captured link layer frame,
+// reporting false for anything other than an unfragmented UDP datagram
+func udpPayload(linkType uint32, frame []byte) ([]byte, bool) {
+	// Skip the link layer header
+	switch linkType {
+	case linkTypeNull:
+		if len(frame) < 4 {
+			return nil, false
+		}
+		frame = frame[4:]
+	case linkTypeEthernet:
+		if len(frame) < 14 {
+			return nil, false
+		}
+		etherType := binary.BigEndian.Uint16(frame[12:14])
+		frame = frame[14:]
+		if etherType == 0x8100 && len(frame) >= 4 {
+			// Skip the 802.1Q VLAN tag
+			frame = frame[4:]
+		}
+	case linkTypeLinuxSLL:
+		if len(frame) < 16 {
+			return nil, false
+		}
+		frame = frame[16:]
+	}
+
+	// Skip the IP header
+	if len(frame) < 1 {
+		return nil, false
+	}
+	switch frame[0] >> 4 {
+	case 4:
+		if len(frame) < 20 {
+			return nil, false
+		}
+		headerLen := int(frame[0]&0x0F) * 4
+		fragment := binary.BigEndian.Uint16(frame[6:8]) & 0x3FFF
+		if frame[9] != 17 || fragment != 0 || len(frame) < headerLen {
+			return nil, false
+		}
+		frame = frame[headerLen:]
+	case 6:
+		if len(frame) < 40 || frame[6] != 17 {
+			return nil, false
+		}
+		frame = frame[40:]
+	default:
+		return nil, false
+	}
+
+	// Strip the UDP header
+	if len(frame) < 8 {
+		return nil, false
+	}
+	length := int(binary.BigEndian.Uint16(frame[4:6]))
+	if length < 8 || length > len(frame) {
+		return nil, false
+	}
+	return frame[8:length], true
+}
+
+// replayMaxGap caps the wait between replayed packets, shortening the silence
+// between transmissions in long captures
+const replayMaxGap = 2 * time.Second
+
+// replayPacer spaces replayed packets like they were received
+type replayPacer struct {
+	started  bool
+	last     time.Time // arrival time of the previous packet
+	hasFrame bool
+	streamID uint16 // stream ID of the previous stream frame
+	frame    uint16 // frame number of the previous stream frame, without EOS
+}
+
+// delay returns how long after the previous packet to replay one that
+// arrived at at. It uses the recorded arrival times, falling back to the
+// frame numbers when they are missing or go backwards, and caps the wait at
+// replayMaxGap.
+func (p *replayPacer) delay(at time.Time, packet []byte) time.Duration {
+	d := m17FrameInterval
+	switch {
+	case !p.started:
+		d = 0
+	case !at.IsZero() && !p.last.IsZero() && !at.Before(p.last):
+		d = at.Sub(p.last)
+	case p.hasFrame && len(packet) >= m17.FrameSize && string(packet[:4]) == m17.MagicM17 &&
+		binary.BigEndian.Uint16(packet[4:6]) == p.streamID:
+		// Wait one frame interval per frame number, so lost frames keep
+		// their time
+		fn := binary.BigEndian.Uint16(packet[34:36]) & m17.FrameNumberMask
+		if frameBefore(p.frame, fn) {
+			d = time.Duration((fn-p.frame)&m17.FrameNumberMask) * m17FrameInterval
+		}
+	}
+
+	p.started = true
+	p.last = at
+	p.hasFrame = len(packet) >= m17.FrameSize && string(packet[:4]) == m17.MagicM17
+	if p.hasFrame {
+		p.streamID = binary.BigEndian.Uint16(packet[4:6])
+		p.frame = binary.BigEndian.Uint16(packet[34:36]) & m17.FrameNumberMask
+	}
+	return min(d, replayMaxGap)
+}
+
+// replayCapture calls handle with each M17 stream frame and M17P packet of a
+// capture or pcap file, returning how many it replayed. When realtime is set
+// packets are spaced by replayPacer, otherwise they are replayed as fast as
+// possible.
+func replayCapture(ctx context.Context, path string, realtime bool, handle func([]byte)) (int, error) {
+	var pacer replayPacer
+	next := time.Now()
+	frames := 0
+	err := readCapture(path, func(at time.Time, packet []byte) error {
+		if len(packet) < 4 || (string(packet[:4]) != m17.MagicM17 && string(packet[:4]) != m17.MagicM17P) {
+			return nil
+		}
+		if realtime {
+			// Schedule from the start of the replay so the time taken to
+			// handle each packet doesn't add up
+			next = next.Add(pacer.delay(at, packet))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Until(next)):
+			}
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		frames++
+		handle(packet)
+		return nil
+	})
+	return frames, err
+}
+
+// replay feeds the M17 stream frames and M17P packets of a capture or pcap
+// file through the packet handler, paced like they were received when
+// realtime is set or as fast as possible otherwise. Other packets need a live
+// relay/reflector and are skipped.
+func (c *Client) replay(path string, realtime bool) error {
+	c.startWorkers()
+
+	frames, err := replayCapture(c.ctx, path, realtime, c.handlePacket)
+	if c.ctx.Err() != nil {
+		return nil
+	}
+	slog.Info("Replay finished", "frames", frames, "file", path)
+
+	// Let the jitter buffer play out the last frames
+	if realtime && c.jitter != nil {
+		select {
+		case <-c.ctx.Done():
+		case <-time.After(time.Duration(c.jitter.depth+1) * m17FrameInterval):
+		}
+	}
+	return err
+}
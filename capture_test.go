@@ -0,0 +1,199 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"go-m17-listen/m17"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCapture is a capture of a short stream from KC1AWV to BROADCAST:
+// frames 0, 1 and 2 received 40ms apart, frame 3 lost and the EOS frame 4
+// received 80ms after frame 2, with a PING received between frames 0 and 1
+const testCapture = "testdata/stream.cap"
+
+// TestReplayCapture replays testCapture, checking only the stream frames are
+// handled and realtime replay keeps the recorded spacing
+func TestReplayCapture(t *testing.T) {
+	want := []uint16{0, 1, 2, 4 | m17.FrameNumberEOS}
+	const recorded = 160 * time.Millisecond
+
+	for _, realtime := range []bool{false, true} {
+		var frames []uint16
+		var times []time.Time
+		n, err := replayCapture(context.Background(), testCapture, realtime, func(packet []byte) {
+			frame, err := m17.ParseM17Frame(packet)
+			if err != nil {
+				t.Errorf("replayed invalid frame: %v", err)
+				return
+			}
+			frames = append(frames, frame.FrameNumber)
+			times = append(times, time.Now())
+		})
+		if err != nil {
+			t.Fatalf("replayCapture(realtime=%v): %v", realtime, err)
+		}
+		if n != len(want) || len(frames) != len(want) {
+			t.Fatalf("replayCapture(realtime=%v) replayed %d frames %v, want %v", realtime, n, frames, want)
+		}
+		for i := range want {
+			if frames[i] != want[i] {
+				t.Errorf("replayCapture(realtime=%v) frame %d = %#04x, want %#04x", realtime, i, frames[i], want[i])
+			}
+		}
+
+		elapsed := times[len(times)-1].Sub(times[0])
+		if realtime && (elapsed < recorded-10*time.Millisecond || elapsed > recorded+time.Second) {
+			t.Errorf("realtime replay took %s, want about %s", elapsed, recorded)
+		}
+		if !realtime && elapsed >= recorded {
+			t.Errorf("replay took %s, want it as fast as possible", elapsed)
+		}
+	}
+}
+
+// TestReplayCaptureCancelled checks replay stops when the context is
+// cancelled
+func TestReplayCaptureCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	n, err := replayCapture(ctx, testCapture, true, func([]byte) { cancel() })
+	if err != context.Canceled || n != 1 {
+		t.Errorf("replayCapture = %d, %v, want 1, %v", n, err, context.Canceled)
+	}
+}
+
+// testStreamFrame returns an M17 stream frame header with the given stream ID
+// and frame number, enough for replayPacer
+func testStreamFrame(streamID, fn uint16) []byte {
+	packet := make([]byte, m17.FrameSize)
+	copy(packet, m17.MagicM17)
+	binary.BigEndian.PutUint16(packet[4:6], streamID)
+	binary.BigEndian.PutUint16(packet[34:36], fn)
+	return packet
+}
+
+// TestReplayPacerDelay checks the delay before each replayed packet follows
+// the recorded times, or the frame numbers when the times can't be used
+func TestReplayPacerDelay(t *testing.T) {
+	t0 := time.Date(2024, 11, 30, 12, 0, 0, 0, time.UTC)
+	packet := []byte(m17.MagicM17P + "payload")
+
+	tests := []struct {
+		name   string
+		first  time.Time
+		prev   []byte
+		at     time.Time
+		packet []byte
+		want   time.Duration
+	}{
+		{"recorded delta", t0, testStreamFrame(1, 0), t0.Add(25 * time.Millisecond), testStreamFrame(1, 1), 25 * time.Millisecond},
+		{"recorded burst", t0, testStreamFrame(1, 0), t0, testStreamFrame(1, 1), 0},
+		{"recorded gap capped", t0, testStreamFrame(1, 4|m17.FrameNumberEOS), t0.Add(time.Hour), testStreamFrame(2, 0), replayMaxGap},
+		{"missing time", t0, testStreamFrame(1, 0), time.Time{}, testStreamFrame(1, 1), m17FrameInterval},
+		{"lost frames", time.Time{}, testStreamFrame(1, 0), time.Time{}, testStreamFrame(1, 3), 3 * m17FrameInterval},
+		{"frame number wraps", time.Time{}, testStreamFrame(1, 0x7FFF), time.Time{}, testStreamFrame(1, 1|m17.FrameNumberEOS), 2 * m17FrameInterval},
+		{"time goes backwards", t0, testStreamFrame(1, 5), t0.Add(-time.Second), testStreamFrame(1, 7), 2 * m17FrameInterval},
+		{"repeated frame", time.Time{}, testStreamFrame(1, 5), time.Time{}, testStreamFrame(1, 5), m17FrameInterval},
+		{"new stream", time.Time{}, testStreamFrame(1, 9), time.Time{}, testStreamFrame(2, 0), m17FrameInterval},
+		{"packet mode", time.Time{}, testStreamFrame(1, 0), time.Time{}, packet, m17FrameInterval},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p replayPacer
+			if d := p.delay(tt.first, tt.prev); d != 0 {
+				t.Errorf("first delay = %s, want 0", d)
+			}
+			if got := p.delay(tt.at, tt.packet); got != tt.want {
+				t.Errorf("delay = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestReadPcapRecordLimit checks a pcap record claiming to be longer than
+// the snapshot length or pcapMaxRecord is rejected before it is read
+func TestReadPcapRecordLimit(t *testing.T) {
+	// A raw IPv4 UDP datagram carrying "PING"
+	datagram := []byte{
+		0x45, 0, 0, 32, 0, 0, 0, 0, 64, 17, 0, 0, 127, 0, 0, 1, 127, 0, 0, 1,
+		0x42, 0x68, 0x42, 0x68, 0, 12, 0, 0, 'P', 'I', 'N', 'G',
+	}
+	pcapFile := func(snapLen, length uint32) []byte {
+		file := binary.LittleEndian.AppendUint32(nil, pcapMagic)
+		file = append(file, 2, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+		file = binary.LittleEndian.AppendUint32(file, snapLen)
+		file = binary.LittleEndian.AppendUint32(file, linkTypeRaw)
+		for _, l := range []uint32{uint32(len(datagram)), length} {
+			file = append(file, make([]byte, 8)...)
+			file = binary.LittleEndian.AppendUint32(file, l)
+			file = binary.LittleEndian.AppendUint32(file, l)
+			file = append(file, datagram...)
+			if l > uint32(len(datagram)) && l <= 1<<20 {
+				// Pad the record so only the limit can reject it
+				file = append(file, make([]byte, l-uint32(len(datagram)))...)
+			}
+		}
+		return file
+	}
+
+	tests := []struct {
+		name    string
+		snapLen uint32
+		length  uint32
+		wantErr bool
+	}{
+		{"within the snapshot length", 65535, uint32(len(datagram)), false},
+		{"longer than the snapshot length", 65535, 65536, true},
+		{"longer than the limit", 0xFFFFFFFF, pcapMaxRecord + 1, true},
+		{"huge without a snapshot length", 0, 0xFFFFFFF0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.pcap")
+			if err := os.WriteFile(path, pcapFile(tt.snapLen, tt.length), 0644); err != nil {
+				t.Fatal(err)
+			}
+			var payloads []string
+			err := readCapture(path, func(_ time.Time, payload []byte) error {
+				payloads = append(payloads, string(payload))
+				return nil
+			})
+			if tt.wantErr {
+				if err == nil {
+					t.Error("readCapture succeeded, want an error")
+				}
+				if len(payloads) != 1 || payloads[0] != "PING" {
+					t.Errorf("payloads before the long record = %q, want [PING]", payloads)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readCapture failed: %v", err)
+			}
+			if len(payloads) != 2 {
+				t.Errorf("got %d payloads, want 2", len(payloads))
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Connection chime settings. The chime is two short rising tones, faded in
+// and out so they don't click.
+const (
+	chimeLowHz        = 660
+	chimeHighHz       = 880
+	chimeToneDuration = 120 * time.Millisecond
+	chimeFade         = 10 * time.Millisecond
+	chimeLevel        = 6000
+)
+
+// chimeAudio returns the samples of the connection chime at codecSampleRate
+func chimeAudio() []int16 {
+	toneSamples := int(chimeToneDuration.Seconds() * codecSampleRate)
+	fadeSamples := int(chimeFade.Seconds() * codecSampleRate)
+	audio := make([]int16, 0, toneSamples*2)
+	for _, hz := range []float64{chimeLowHz, chimeHighHz} {
+		for i := 0; i < toneSamples; i++ {
+			gain := math.Min(1, float64(min(i, toneSamples-1-i))/float64(fadeSamples))
+			t := float64(i) / codecSampleRate
+			audio = append(audio, int16(chimeLevel*gain*math.Sin(2*math.Pi*hz*t)))
+		}
+	}
+	return audio
+}
+
+// playChime queues the connection chime on the speakers, at the playback
+// volume and unless muted, and flashes the GUI window title. Raw PCM outputs
+// such as stdout and the FIFO only ever carry received audio.
+func (c *Client) playChime() {
+	flashGUI()
+	if c.muted.Load() {
+		return
+	}
+	for _, sink := range c.sinks {
+		if sink.name == speakersSink {
+			sink.write(c.applyVolume(chimeAudio()))
+		}
+	}
+}
@@ -19,131 +19,517 @@ package main
 
 import (
 	"context"
-	"encoding/binary"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
 	"fmt"
 	"go-m17-listen/codec2"
-	"log"
+	"go-m17-listen/m17"
+	"io"
+	"log/slog"
+	"math"
 	"net"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// m17FrameSamples is the number of 8kHz audio samples carried by one M17
+// stream frame (40ms)
+const m17FrameSamples = 320
+
+// codecModeAuto selects the Codec 2 mode from the M17 Type field of each
+// stream instead of using a fixed mode
+const codecModeAuto = -1
+
+// Playback volume limits
+const (
+	minVolume = 0.0
+	maxVolume = 2.0
+)
 
-	"github.com/hajimehoshi/oto"
+// Read loop timing. Reads time out every readPollInterval to check whether the
+// client has been cancelled, and the loop pauses for readErrorBackoff after a
+// read error.
+const (
+	readPollInterval = 500 * time.Millisecond
+	readErrorBackoff = 100 * time.Millisecond
 )
 
-// Packet MAGIC constants
+// udpBufferSize is the size of the UDP read buffer. The largest packet is an
+// M17P packet of 859 bytes, reads filling the buffer are treated as
+// truncated.
+const udpBufferSize = 1024
+
+// Audio output buffer size limits in bytes. At 8kHz 16-bit mono, 16000 bytes hold one
+// second of audio.
 const (
-	MagicLSTN = "LSTN"
-	MagicACKN = "ACKN"
-	MagicNACK = "NACK"
-	MagicPING = "PING"
-	MagicPONG = "PONG"
-	MagicDISC = "DISC"
-	MagicM17  = "M17 "
+	defaultAudioBuffer = 4096
+	minAudioBuffer     = 512
+	maxAudioBuffer     = 32768
 )
 
+// ClientConfig holds optional client settings
+type ClientConfig struct {
+	Network        string        // UDP network to use (udp, udp4 or udp6)
+	CodecMode      int           // Codec 2 mode, or codecModeAuto
+	JitterDelay    time.Duration // Audio buffered before playback, 0 disables
+	Conceal        string        // Lost frame concealment (silence or repeat), empty uses silence
+	ActivityLog    string        // Path of the activity log, empty disables
+	ActivityFormat string        // Activity log format (csv or jsonl)
+	SMSLog         string        // Path of the text message log, empty disables
+	Timeout        time.Duration // Keepalive timeout before reconnecting, 0 disables
+	Volume         float64       // Playback gain (0.0 to 2.0)
+	AudioBackend   string        // Audio backend (oto, pulse, alsa), empty uses oto
+	AudioBuffer    int           // Audio output buffer size in bytes, 0 uses the default
+	OutputRate     int           // Audio output sample rate in Hz, 0 plays at 8kHz
+	PCMOutput      io.Writer     // Receives raw PCM instead of the speakers when set
+	Fallbacks      []string      // Relay/reflector addresses to fail over to, in order
+	Reconnect      bool          // Re-subscribe when the relay/reflector sends DISC instead of exiting
+	FIFO           string        // Named pipe to write raw PCM to instead of the speakers, empty disables
+	Speakers       bool          // Also play on the speakers when PCMOutput or FIFO is set
+	NoAudio        bool          // Never play on the speakers, for display-only monitoring
+	NoChime        bool          // Don't chime when the relay/reflector accepts the connection
+	Filter         bool          // High-pass filter decoded audio
+	FilterCutoff   float64       // High-pass cutoff in Hz, 0 uses the default
+	NoiseGate      float64       // Noise gate threshold in dBFS when filtering, 0 disables
+	Squelch        float64       // Squelch threshold in dBFS, 0 disables
+	MetricsAddr    string        // Address to serve Prometheus metrics on, empty disables
+	HTTPStreamAddr string        // Address to serve the live audio over HTTP on, empty disables
+	Webhook        string        // URL to post stream events to, empty disables
+	Watch          watchList     // Source callsigns to alert on
+	OnlySRC        watchList     // Source callsigns to play, empty plays all
+	FilterCAN      canFilter     // Channel access numbers of the streams to handle, empty handles all
+	Capture        string        // File to record received packets to, empty disables
+	ControlSocket  string        // Unix socket path to serve status and commands on, empty disables
+	SaveLSF        string        // Directory to save the link setup frame of each stream to, empty disables
+	MaxAttempts    int           // Attempts to connect at startup, 0 retries forever
+	Once           bool          // Exit after the first complete transmission
+	OnceTimeout    time.Duration // How long to wait for the transmission in Once mode
+	CompactOutput  io.Writer     // Receives one line per finished transmission when set
+	PingInterval   time.Duration // How often to PING the relay/reflector to measure the round-trip time, 0 disables
+	MaxTalk        time.Duration // Transmission length to alert on, 0 disables
+	Duration       time.Duration // How long to run before disconnecting, 0 runs until stopped
+	DebugFrames    bool          // Dump every field of each frame to the log and the frame details pane
+	LocalAddr      *net.UDPAddr  // Local address to send from, nil uses an ephemeral port
+	Key            []byte        // AES key to decrypt encrypted streams with, nil skips them
+	ScramblerKey   uint32        // Scrambler seed to descramble scrambled streams with, 0 skips them
+}
+
 // Client represents a M17 client
 type Client struct {
+	connMu       sync.Mutex
 	conn         *net.UDPConn
 	callsign     string
+	network      string
+	relayHost    string
+	relayHosts   []string
+	relayIndex   int
+	localAddr    *net.UDPAddr
 	relayAddr    *net.UDPAddr
+	lastRx       time.Time
+	lastPingTime time.Time
+	keepalive    string
+	state        string
+	timeout      time.Duration
+	attempts     int
 	moduleLetter byte
-	codec2       *codec2.Codec2
-	player       *oto.Player
+	codecMode    int
+	decoders     map[int]*codec2.Codec2
+	lastStreamID uint16
+	sinks        []*audioSink
+	audioErr     error
+	chime        bool
+	maxTalk      time.Duration
+	pingInterval time.Duration
+	rtt          *rttStats
+	compact      io.Writer
+	talkAlerted  bool
+	filter       *audioFilter
+	squelch      *squelch
+	volume       atomic.Uint64
+	muted        atomic.Bool
+	meter        levelMeter
+	jitter       *jitterBuffer
+	conceal      concealer
+	nextFrame    uint16
+	sequencing   bool
+	activity     *activityLog
+	smsLog       *smsLog
+	smsHistory   []string
+	stream       *activityRecord
+	crcFailures  int
+	decodeErrs   decodeErrors
+	frameStats   frameStats
+	metrics      *metrics
+	metricsAddr  string
+	httpStream   *audioStream
+	streamAddr   string
+	webhook      *webhook
+	watch        watchList
+	capture      *captureWriter
+	alerting     bool
+	metaText     metaText
+	packets      packetAssembler
+	aesKey       []byte
+	aes          cipher.Block
+	scrambler    *scrambler
 	ctx          context.Context
 	cancel       context.CancelFunc
+	ackn         chan struct{}
+	transmitted  chan struct{}
+	transmitOnce sync.Once
+	onceTimeout  time.Duration
+	duration     time.Duration
+	debugFrames  bool
+	canFilter    canFilter
+	onlySRC      watchList
+	controlPath  string
+	current      atomic.Pointer[activityRecord]
+	stop         chan struct{}
+	stopOnce     sync.Once
+	receivers    sync.WaitGroup
+	failOnce     sync.Once
+	failErr      error
+	lsfDir       string
 	discChan     chan struct{}
+	discOnce     sync.Once
+	closeOnce    sync.Once
+	switchUntil  atomic.Int64
+	reconnect    bool
+	quitting     atomic.Bool
+	pongRetrying atomic.Bool
+	reconnecting atomic.Bool
+	failedOver   atomic.Bool
 }
 
 // NewClient creates a new M17 client
-func NewClient(callsign, relayAddr string, moduleLetter byte) (*Client, error) {
-	// Resolve relay/reflector address
-	addr, err := net.ResolveUDPAddr("udp", relayAddr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to resolve address: %w", err)
+func NewClient(callsign, relayAddr string, moduleLetter byte, config ClientConfig) (*Client, error) {
+	// The relay/reflector is dialed by connect
+	network := config.Network
+	if network == "" {
+		network = "udp"
 	}
 
-	// Dial UDP connection to relay/reflector
-	conn, err := net.DialUDP("udp", nil, addr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to dial: %w", err)
+	// Initialize Codec 2 in the requested mode, falling back to 3200 bps as
+	// the initial decoder when the mode is detected per stream
+	initialMode := config.CodecMode
+	if config.CodecMode == codecModeAuto {
+		initialMode = codec2.MODE_3200
 	}
-
-	// Initialize Codec 2 at 3200 bps
-	codec2, err := codec2.New(codec2.MODE_3200)
+	decoder, err := codec2.New(initialMode)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize codec2: %w", err)
 	}
 
-	// Initialize Oto player
-	otoCtx, err := oto.NewContext(8000, 1, 2, 4096)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Oto context: %w", err)
+	// Initialize the audio outputs, playing on the speakers unless audio
+	// only goes to a raw PCM output or audio is disabled
+	var sinks []*audioSink
+	var audioErr error
+	if config.PCMOutput != nil {
+		sinks = append(sinks, newAudioSink("stdout", writerPlayer{config.PCMOutput}, nil, true))
+	}
+	if config.FIFO != "" {
+		player, err := newFIFOPlayer(config.FIFO)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, newAudioSink("FIFO", player, nil, false))
+	}
+	if (len(sinks) == 0 || config.Speakers) && !config.NoAudio {
+		audioBuffer := config.AudioBuffer
+		if audioBuffer == 0 {
+			audioBuffer = defaultAudioBuffer
+		}
+		outputRate := config.OutputRate
+		if outputRate == 0 {
+			outputRate = codecSampleRate
+		}
+		var resample *resampler
+		if outputRate != codecSampleRate {
+			resample = newResampler(outputRate)
+		}
+		// Keep decoding and displaying streams when there is no working
+		// audio device
+		player, err := newPlayer(config.AudioBackend, outputRate, audioBuffer)
+		if err != nil {
+			slog.Warn("audio unavailable, continuing without playing audio", "err", err)
+			updateField("Error", fmt.Sprintf("audio unavailable: %v", err))
+			audioErr = err
+		} else {
+			sinks = append(sinks, newAudioSink(speakersSink, player, resample, false))
+		}
 	}
-	player := otoCtx.NewPlayer()
 
 	// Create context with cancel function
 	ctx, cancel := context.WithCancel(context.Background())
 
-	// Create and return new client
-	return &Client{
-		conn:         conn,
+	// Create new client
+	c := &Client{
 		callsign:     callsign,
-		relayAddr:    addr,
+		network:      network,
+		relayHost:    relayAddr,
+		relayHosts:   append([]string{relayAddr}, config.Fallbacks...),
+		localAddr:    config.LocalAddr,
+		attempts:     config.MaxAttempts,
+		lastRx:       time.Now(),
+		lastPingTime: time.Now(),
+		keepalive:    m17.MagicPING,
+		timeout:      config.Timeout,
 		moduleLetter: moduleLetter,
-		codec2:       codec2,
-		player:       player,
+		codecMode:    config.CodecMode,
+		decoders:     map[int]*codec2.Codec2{initialMode: decoder},
+		sinks:        sinks,
+		audioErr:     audioErr,
+		chime:        !config.NoChime,
+		maxTalk:      config.MaxTalk,
+		pingInterval: config.PingInterval,
+		compact:      config.CompactOutput,
+		metricsAddr:  config.MetricsAddr,
+		streamAddr:   config.HTTPStreamAddr,
+		watch:        config.Watch,
+		debugFrames:  config.DebugFrames,
+		canFilter:    config.FilterCAN,
+		onlySRC:      config.OnlySRC,
+		reconnect:    config.Reconnect,
+		controlPath:  config.ControlSocket,
+		stop:         make(chan struct{}),
+		lsfDir:       config.SaveLSF,
+		duration:     config.Duration,
 		ctx:          ctx,
 		cancel:       cancel,
+		ackn:         make(chan struct{}, 1),
 		discChan:     make(chan struct{}),
-	}, nil
+	}
+
+	c.setVolume(config.Volume)
+	c.conceal.strategy = config.Conceal
+	if config.MetricsAddr != "" {
+		c.metrics = newMetrics(c.keepaliveAge)
+	}
+	if config.Webhook != "" {
+		c.webhook = newWebhook(config.Webhook)
+	}
+	if config.HTTPStreamAddr != "" {
+		c.httpStream = newAudioStream()
+	}
+	if config.PingInterval > 0 {
+		c.rtt = &rttStats{}
+	}
+	updateField("Module", strings.TrimSpace(string(moduleLetter)))
+	updateField("Relay", relayAddr)
+	updateField("Audio", c.audioStatus())
+
+	// Clean up decoded audio if requested
+	if config.Filter {
+		cutoff := config.FilterCutoff
+		if cutoff == 0 {
+			cutoff = defaultFilterCutoff
+		}
+		c.filter = newAudioFilter(cutoff, config.NoiseGate)
+	}
+
+	// Suppress playback of quiet frames if requested
+	if config.Squelch != 0 {
+		c.squelch = newSquelch(config.Squelch)
+	}
+	updateField("Squelch", c.squelch.String())
+
+	// Buffer decoded audio before playback unless disabled
+	if config.JitterDelay > 0 {
+		c.jitter = newJitterBuffer(config.JitterDelay, config.Conceal, c.playAudio)
+	}
+
+	// Set up decryption if a key was given
+	if config.Key != nil {
+		c.aesKey = config.Key
+		c.aes, err = aes.NewCipher(config.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key: %w", err)
+		}
+	}
+
+	if config.Once {
+		c.transmitted = make(chan struct{})
+		c.onceTimeout = config.OnceTimeout
+	}
+	if config.ScramblerKey != 0 {
+		c.scrambler = &scrambler{seed: config.ScramblerKey}
+	}
+
+	// Open the capture file if requested
+	if config.Capture != "" {
+		c.capture, err = newCaptureWriter(config.Capture)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Create the LSF directory if requested
+	if config.SaveLSF != "" {
+		if err := os.MkdirAll(config.SaveLSF, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create LSF directory: %w", err)
+		}
+	}
+
+	// Open the activity log if requested
+	if config.ActivityLog != "" {
+		c.activity, err = newActivityLog(config.ActivityLog, config.ActivityFormat)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Open the SMS log if requested
+	if config.SMSLog != "" {
+		c.smsLog, err = newSMSLog(config.SMSLog)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// startReceiver runs receive, which handles received packets, on its own
+// goroutine. The active stream belongs to that goroutine, so it is finished
+// there once receive returns, and Close waits for it before closing the logs.
+func (c *Client) startReceiver(receive func()) {
+	c.receivers.Add(1)
+	go func() {
+		defer c.receivers.Done()
+		defer c.finishStream()
+		receive()
+	}()
 }
 
-// Listen listens for incoming packets
+// Listen listens for incoming packets. It runs on the receiver goroutine
+// started with startReceiver.
 func (c *Client) listen() {
-	buf := make([]byte, 64)
+	c.startWorkers()
+	if c.timeout > 0 {
+		go c.watchdog(c.timeout)
+	}
+	go c.monitorLinkHealth()
+	if c.pingInterval > 0 {
+		go c.sendPings(c.pingInterval)
+	}
+
+	buf := make([]byte, udpBufferSize)
 	for {
 		select {
 		case <-c.ctx.Done():
 			return
 		default:
-			n, addr, err := c.conn.ReadFromUDP(buf)
+			// Wake up periodically to notice cancellation without
+			// relying on the connection being closed
+			conn := c.connection()
+			if err := conn.SetReadDeadline(time.Now().Add(readPollInterval)); err != nil {
+				slog.Debug("failed to set read deadline", "err", err)
+			}
+			n, addr, err := conn.ReadFromUDP(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				continue
+			}
 			if err != nil {
-				if ne, ok := err.(*net.OpError); ok && ne.Err.Error() == "use of closed network connection" {
+				if errors.Is(err, net.ErrClosed) {
+					// The watchdog replaced the connection, keep reading
+					if conn != c.connection() {
+						continue
+					}
+					if !c.fixedLocalPort() {
+						return
+					}
+
+					// The watchdog released a fixed local port to dial
+					// again, wait for the new connection
+					select {
+					case <-c.ctx.Done():
+						return
+					case <-time.After(100 * time.Millisecond):
+					}
+					continue
+				}
+				slog.Error("failed to read from UDP", "err", err)
+				updateField("Error", fmt.Sprintf("failed to read from UDP: %v", err))
+
+				// Don't spin on an error that repeats on every read
+				select {
+				case <-c.ctx.Done():
 					return
+				case <-time.After(readErrorBackoff):
 				}
-				log.Printf("failed to read from UDP: %v", err)
-				updateTUI("Error", fmt.Sprintf("failed to read from UDP: %v", err))
-				updateGUI("Error", fmt.Sprintf("failed to read from UDP: %v", err))
 				continue
 			}
 
 			// Check if the packet is from the connected relay/reflector
-			if !addr.IP.Equal(c.relayAddr.IP) || addr.Port != c.relayAddr.Port {
-				log.Printf("received packet from unknown source: %v", addr)
-				updateTUI("Error", fmt.Sprintf("received packet from unknown source: %v", addr))
-				updateGUI("Error", fmt.Sprintf("received packet from unknown source: %v", addr))
+			if !sameUDPAddr(addr, c.relay()) {
+				slog.Warn("received packet from unknown source", "addr", addr)
+				updateField("Error", fmt.Sprintf("received packet from unknown source: %v", addr))
+				continue
+			}
+
+			// A read filling the whole buffer may have been truncated
+			if n == len(buf) {
+				slog.Warn("ignoring packet that may have been truncated", "bytes", n)
+				updateField("Error", fmt.Sprintf("ignoring packet that may have been truncated: %d bytes or more", n))
 				continue
 			}
 
+			c.touch()
+			if err := c.capture.write(buf[:n]); err != nil {
+				slog.Error("failed to write capture file", "err", err)
+				updateField("Error", fmt.Sprintf("failed to write capture file: %v", err))
+			}
 			c.handlePacket(buf[:n])
 		}
 	}
 }
 
+// startWorkers starts the goroutines handling decoded audio, metrics,
+// webhooks, the HTTP audio stream and the control socket
+func (c *Client) startWorkers() {
+	for _, sink := range c.sinks {
+		sink.start()
+	}
+	if c.jitter != nil {
+		go c.jitter.run(c.ctx)
+	}
+	go c.meter.run(c.ctx)
+	go c.reportDecodeErrors(c.ctx)
+	if c.metrics != nil {
+		go c.serveMetrics(c.metricsAddr)
+	}
+	if c.webhook != nil {
+		c.webhook.start()
+	}
+	if c.httpStream != nil {
+		go c.serveStream(c.streamAddr)
+	}
+	if c.controlPath != "" {
+		go c.serveControl(c.controlPath)
+	}
+}
+
 // sendLSTN sends a LSTN packet to the relay/reflector
 func (c *Client) sendLSTN() error {
-	encodedCallsign, err := encodeCallsign(c.callsign)
+	encodedCallsign, err := m17.EncodeCallsign(c.callsign)
 	if err != nil {
 		return fmt.Errorf("failed to encode callsign: %w", err)
 	}
 
-	packet := append([]byte(MagicLSTN), encodedCallsign...)
+	packet := append([]byte(m17.MagicLSTN), encodedCallsign...)
 
 	// Append module letter if present
-	if c.moduleLetter != 0 {
-		packet = append(packet, c.moduleLetter)
+	if module := c.module(); module != 0 {
+		packet = append(packet, module)
 	}
 
-	_, err = c.conn.Write(packet)
+	err = c.write(packet)
 	if err != nil {
 		return fmt.Errorf("failed to send LSTN packet: %w", err)
 	}
@@ -151,15 +537,51 @@ func (c *Client) sendLSTN() error {
 	return nil
 }
 
+// module returns the module letter of the reflector being listened to
+func (c *Client) module() byte {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	return c.moduleLetter
+}
+
+// switchModule re-subscribes to a different reflector module by sending DISC
+// for the current module and LSTN for the new one
+func (c *Client) switchModule(letter byte) error {
+	if letter == c.module() {
+		return nil
+	}
+
+	// Expect the reply to this DISC for as long as disconnect would wait
+	c.switchUntil.Store(time.Now().Add(discTimeout).UnixNano())
+	if err := c.sendDISC(); err != nil {
+		c.switchUntil.Store(0)
+		return err
+	}
+
+	c.connMu.Lock()
+	c.moduleLetter = letter
+	c.connMu.Unlock()
+
+	if err := c.sendLSTN(); err != nil {
+		c.switchUntil.Store(0)
+		return err
+	}
+
+	slog.Info("Switched module", "module", string(letter))
+	updateField("Module", string(letter))
+	updateField("Status", fmt.Sprintf("Switched to module %c", letter))
+	return nil
+}
+
 // sendDISC sends a DISC packet to the relay/reflector
 func (c *Client) sendDISC() error {
-	encodedCallsign, err := encodeCallsign(c.callsign)
+	encodedCallsign, err := m17.EncodeCallsign(c.callsign)
 	if err != nil {
 		return fmt.Errorf("failed to encode callsign: %w", err)
 	}
 
-	packet := append([]byte(MagicDISC), encodedCallsign...)
-	_, err = c.conn.Write(packet)
+	packet := append([]byte(m17.MagicDISC), encodedCallsign...)
+	err = c.write(packet)
 	if err != nil {
 		return fmt.Errorf("failed to send DISC packet: %w", err)
 	}
@@ -167,195 +589,902 @@ func (c *Client) sendDISC() error {
 	return nil
 }
 
+// Disconnect settings, DISC is re-sent every discInterval in case it or the
+// reply is lost until the relay/reflector replies or discTimeout passes
+const (
+	discTimeout  = 5 * time.Second
+	discInterval = 2 * time.Second
+)
+
+// disconnect sends DISC and waits for the relay/reflector to reply with DISC.
+// It reports whether the reply arrived.
+func (c *Client) disconnect() bool {
+	c.quitting.Store(true)
+
+	// The DISC has already arrived when the relay/reflector dropped the
+	// subscription first
+	reason := DisconnectClient
+	select {
+	case <-c.discChan:
+		reason = DisconnectRelay
+	default:
+	}
+	defer func() { jsonEvents.emit(c.connectionEvent(EventDisconnected, reason)) }()
+
+	ticker := time.NewTicker(discInterval)
+	defer ticker.Stop()
+	timeout := time.After(discTimeout)
+	for {
+		if err := c.sendDISC(); err != nil {
+			slog.Error("failed to disconnect", "err", err)
+		}
+		select {
+		case <-c.discChan:
+			return true
+		case <-timeout:
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
 // handlePacket handles incoming packets
 func (c *Client) handlePacket(packet []byte) {
 	if len(packet) < 4 {
 		return
 	}
 
+	// Packets from the previous relay/reflector's streams won't continue
+	if c.failedOver.Swap(false) {
+		c.dropStream()
+	}
+
 	magic := string(packet[:4])
 	switch magic {
-	case MagicPING:
+	case m17.MagicPING, m17.MagicPONG, m17.MagicACKN, m17.MagicNACK, m17.MagicDISC, m17.MagicM17, m17.MagicM17P:
+		c.metrics.packet(magic)
+	}
+	switch magic {
+	case m17.MagicPING:
 		c.handlePing()
-	case MagicACKN:
+	case m17.MagicPONG:
+		c.handlePong()
+	case m17.MagicACKN:
 		c.handleACKN()
-	case MagicNACK:
-		c.handleNACK()
-	case MagicDISC:
+	case m17.MagicNACK:
+		c.handleNACK(packet[4:])
+	case m17.MagicDISC:
 		c.handleDISC()
-	case MagicM17:
+	case m17.MagicM17:
 		c.handleM17(packet)
+	case m17.MagicM17P:
+		c.handleM17P(packet)
 	}
 }
 
 // handlePing handles a PING packet
 func (c *Client) handlePing() {
-	encodedCallsign, err := encodeCallsign(c.callsign)
+	c.touchKeepalive(m17.MagicPING)
+
+	encodedCallsign, err := m17.EncodeCallsign(c.callsign)
 	if err != nil {
-		log.Printf("failed to encode callsign: %v", err)
-		updateTUI("Error", fmt.Sprintf("failed to encode callsign: %v", err))
-		updateGUI("Error", fmt.Sprintf("failed to encode callsign: %v", err))
+		slog.Error("failed to encode callsign", "err", err)
+		updateField("Error", fmt.Sprintf("failed to encode callsign: %v", err))
 		return
 	}
 
-	pongPacket := append([]byte(MagicPONG), encodedCallsign...)
-	_, err = c.conn.Write(pongPacket)
-	if err != nil {
-		log.Printf("failed to send PONG packet: %v", err)
-		updateTUI("Error", fmt.Sprintf("failed to send PONG packet: %v", err))
-		updateGUI("Error", fmt.Sprintf("failed to send PONG packet: %v", err))
+	c.sendPong(append([]byte(m17.MagicPONG), encodedCallsign...))
+}
+
+// sendPong answers a PING from the receiver goroutine without waiting out
+// write retries, which would hold up the packets received meanwhile. A
+// temporary failure is retried by write on its own goroutine, one at a time
+// since a PONG sent by the retry answers any PING received meanwhile.
+func (c *Client) sendPong(packet []byte) {
+	conn := c.connection()
+	if conn == nil {
+		return
+	}
+	report := func(err error) {
+		slog.Error("failed to send PONG packet", "err", err)
+		updateField("Error", fmt.Sprintf("failed to send PONG packet: %v", err))
+	}
+
+	_, err := conn.Write(packet)
+	switch {
+	case err == nil:
+	case !isTemporary(err):
+		report(err)
+	case c.pongRetrying.CompareAndSwap(false, true):
+		go func() {
+			defer c.pongRetrying.Store(false)
+			if err := c.write(packet); err != nil {
+				report(err)
+			}
+		}()
+	}
+}
+
+// handlePong handles a PONG packet, which some relays/reflectors echo or send
+// on their own, as a keepalive and as the answer to the client's own PING
+func (c *Client) handlePong() {
+	slog.Debug("Received PONG packet")
+	c.touchKeepalive(m17.MagicPONG)
+	if c.rtt.pong(time.Now()) {
+		updateField("RTT", c.rtt.String())
 	}
 }
 
 // handleACKN handles an ACKN packet
 func (c *Client) handleACKN() {
-	log.Println("Connection accepted by relay/reflector")
-	updateTUI("Status", "Connection accepted by relay/reflector")
-	updateGUI("Status", "Connection accepted by relay/reflector")
+	slog.Info("Connection accepted by relay/reflector")
+	c.setState(StateListening)
+	jsonEvents.emit(c.connectionEvent(EventConnected, ""))
+	if c.chime {
+		c.playChime()
+	}
+
+	// Wake awaitACKN without blocking when nobody is waiting
+	select {
+	case c.ackn <- struct{}{}:
+	default:
+	}
+}
+
+// handleNACK handles a NACK packet, reporting the reason some
+// relays/reflectors send after the magic
+func (c *Client) handleNACK(extra []byte) {
+	status := "Connection not accepted by relay/reflector"
+	if reason := nackReason(extra); reason != "" {
+		status += ": " + reason
+	}
+	setTUIConnected(false)
+	updateField("Status", status)
+	c.fail(errors.New(status))
+}
+
+// nackReason describes the bytes following the NACK magic, as text when they
+// are printable and as hex otherwise, e.g. "module full" or "01 fe".
+// It returns an empty string when there are none.
+func nackReason(extra []byte) string {
+	text := strings.TrimRight(string(extra), "\x00 ")
+	if text == "" {
+		return ""
+	}
+	printable := utf8.ValidString(text)
+	for _, r := range text {
+		if !unicode.IsPrint(r) {
+			printable = false
+			break
+		}
+	}
+	if printable {
+		return text
+	}
+	return fmt.Sprintf("% x", extra)
 }
 
-// handleNACK handles a NACK packet
-func (c *Client) handleNACK() {
-	log.Println("Connection not accepted by relay/reflector")
-	updateTUI("Status", "Connection not accepted by relay/reflector")
-	updateGUI("Status", "Connection not accepted by relay/reflector")
-	c.sendDISC()
-	c.cancel()
-	c.conn.Close()
-	c.player.Close()
-	os.Exit(1)
+// switchReplyExpected reports whether a DISC is the reply to the DISC sent by
+// switchModule, which is expected once and only until discTimeout passes
+func (c *Client) switchReplyExpected() bool {
+	until := c.switchUntil.Swap(0)
+	return until != 0 && time.Now().UnixNano() < until
 }
 
 // handleDISC handles a DISC packet
 func (c *Client) handleDISC() {
-	log.Println("Received DISC packet")
-	updateTUI("Status", "Received DISC packet")
-	updateGUI("Status", "Received DISC packet")
-	close(c.discChan)
+	// The relay/reflector acknowledges the DISC sent when switching modules
+	if c.switchReplyExpected() {
+		slog.Debug("Received DISC packet for previous module")
+		return
+	}
+
+	// The relay/reflector dropped the subscription on its own, e.g. when it
+	// restarts
+	if !c.quitting.Load() {
+		if c.reconnect {
+			slog.Warn("Disconnected by relay/reflector, re-subscribing")
+			updateField("Status", "Disconnected by relay/reflector, re-subscribing")
+			jsonEvents.emit(c.connectionEvent(EventDisconnected, DisconnectRelay))
+			c.dropStream()
+			go c.resubscribe()
+			return
+		}
+		slog.Warn("Disconnected by relay/reflector")
+		updateField("Status", "Disconnected by relay/reflector")
+		c.discOnce.Do(func() { close(c.discChan) })
+		c.requestStop()
+		return
+	}
+
+	slog.Info("Received DISC packet")
+	updateField("Status", "Received DISC packet")
+	c.discOnce.Do(func() { close(c.discChan) })
 }
 
 // handleM17 handles a M17 packet
 func (c *Client) handleM17(packet []byte) {
-	if len(packet) < 54 {
-		log.Printf("invalid M17 packet length: %d", len(packet))
-		updateTUI("Error", fmt.Sprintf("invalid M17 packet length: %d", len(packet)))
-		updateGUI("Error", fmt.Sprintf("invalid M17 packet length: %d", len(packet)))
+	if len(packet) < m17.FrameSize {
+		c.decodeError(fmt.Errorf("invalid M17 packet length: %d", len(packet)))
 		return
 	}
 
-	// Parse M17 packet fields
-	streamID := binary.BigEndian.Uint16(packet[4:6])
-	lich := packet[6:34]
-	frameNumber := binary.BigEndian.Uint16(packet[34:36])
-	payload := packet[36:52]
-	// reserved := packet[52:54] // Reserved field, not used
+	if c.debugFrames {
+		logFrame(packet)
+	}
 
-	// Parse LICH fields
-	dst := decodeCallsign(lich[0:6])
-	src := decodeCallsign(lich[6:12])
-	typ := binary.BigEndian.Uint16(lich[12:14])
-	meta := lich[14:28]
+	// Verify the CRC over the frame before trusting any of its fields
+	frame, err := m17.ParseM17Frame(packet)
+	if errors.Is(err, m17.ErrBadCRC) {
+		c.crcFailures++
+		c.metrics.crcFailure()
+		updateField("CRCFailures", fmt.Sprintf("%d", c.crcFailures))
+		c.decodeError(fmt.Errorf("ignoring M17 packet: %w", err))
+		return
+	} else if err != nil {
+		c.decodeError(fmt.Errorf("ignoring M17 packet: %w", err))
+		return
+	}
 
-	// Parse Type field
-	packetStreamIndicator := typ & 0x0001
-	dataTypeIndicator := (typ >> 1) & 0x0003
-	encryptionType := (typ >> 3) & 0x0003
-	encryptionSubtype := (typ >> 5) & 0x0003
-	channelAccessNumber := (typ >> 7) & 0x000F
+	// M17 packet fields
+	streamID := frame.StreamID
+	frameNumber := frame.FrameNumber
+	eos := frame.EOS()
+	payload := frame.Payload
 
-	// Log packet fields
-	log.Printf("Received M17 packet: StreamID=0x%X, FrameNumber=0x%X, DST=%s, SRC=%s, TYPE=0x%X, META=%x", streamID, frameNumber, dst, src, typ, meta)
-	log.Printf("Type field breakdown: PacketStreamIndicator=%d, DataTypeIndicator=%d, EncryptionType=%d, EncryptionSubtype=%d, ChannelAccessNumber=%d",
-		packetStreamIndicator, dataTypeIndicator, encryptionType, encryptionSubtype, channelAccessNumber)
-
-	// Update TUI fields
-	updateTUI("StreamID", fmt.Sprintf("%d", streamID))
-	updateTUI("FrameNumber", fmt.Sprintf("%d", frameNumber))
-	updateTUI("DST", dst)
-	updateTUI("SRC", src)
-	updateTUI("TYPE", fmt.Sprintf("%d", typ))
-	updateTUI("META", fmt.Sprintf("%x", meta))
-	updateTUI("Payload", fmt.Sprintf("%x", payload))
-	updateTUI("PacketStreamIndicator", fmt.Sprintf("%d", packetStreamIndicator))
-	updateTUI("DataTypeIndicator", fmt.Sprintf("%d", dataTypeIndicator))
-	updateTUI("EncryptionType", fmt.Sprintf("%d", encryptionType))
-	updateTUI("EncryptionSubtype", fmt.Sprintf("%d", encryptionSubtype))
-	updateTUI("ChannelAccessNumber", fmt.Sprintf("%d", channelAccessNumber))
-
-	// Update GUI with packet fields
-	updateGUI("StreamID", fmt.Sprintf("%X", streamID))
-	updateGUI("FrameNumber", fmt.Sprintf("%X", frameNumber))
-	updateGUI("DST", dst)
-	updateGUI("SRC", src)
-	updateGUI("TYPE", fmt.Sprintf("%X", typ))
-	updateGUI("META", fmt.Sprintf("%x", meta))
-	updateGUI("PacketStreamIndicator", fmt.Sprintf("%d", packetStreamIndicator))
-	updateGUI("DataTypeIndicator", fmt.Sprintf("%d", dataTypeIndicator))
-	updateGUI("EncryptionType", fmt.Sprintf("%d", encryptionType))
-	updateGUI("EncryptionSubtype", fmt.Sprintf("%d", encryptionSubtype))
-	updateGUI("ChannelAccessNumber", fmt.Sprintf("%d", channelAccessNumber))
-	updateGUI("Payload", fmt.Sprintf("%x", payload))
-
-	// Filter out packets that are not stream mode or are encrypted
-	if packetStreamIndicator == 0 || encryptionType != 0 {
-		log.Printf("Ignoring packet mode or encrypted packet: TYPE=%d", typ)
-		updateTUI("Status", fmt.Sprintf("Ignoring packet mode or encrypted packet: TYPE=%d", typ))
-		updateGUI("Status", fmt.Sprintf("Ignoring packet mode or encrypted packet: TYPE=%d", typ))
+	// LICH fields
+	dst := frame.LSF.DST
+	src := frame.LSF.SRC
+	typ := uint16(frame.LSF.Type)
+	meta := frame.LSF.Meta
+
+	// Type field
+	packetStreamIndicator := frame.LSF.Type.PacketStreamIndicator()
+	dataTypeIndicator := frame.LSF.Type.DataTypeIndicator()
+	encryptionType := frame.LSF.Type.EncryptionType()
+	encryptionSubtype := frame.LSF.Type.EncryptionSubtype()
+	channelAccessNumber := frame.LSF.Type.ChannelAccessNumber()
+
+	// Ignore streams on other logical channels when filtering by CAN
+	if !c.canFilter.allows(channelAccessNumber) {
+		slog.Debug("Ignoring M17 packet on filtered channel access number", "can", channelAccessNumber, "src", src)
 		return
 	}
 
-	// Filter out packets that are not voice or voice + data
-	if dataTypeIndicator != 0b10 && dataTypeIndicator != 0b11 {
-		log.Printf("Ignoring non-voice packet: TYPE=%d", typ)
-		updateTUI("Status", fmt.Sprintf("Ignoring non-voice packet: TYPE=%d", typ))
-		updateGUI("Status", fmt.Sprintf("Ignoring non-voice packet: TYPE=%d", typ))
+	// Drop frames the relay/reflector sent more than once, which would
+	// otherwise be played twice
+	if c.frameStats.duplicate(streamID, frameNumber, src) {
+		slog.Debug("Dropping duplicate frame", "stream_id", formatHex(streamID), "frame_number", formatHex(frameNumber))
+		updateField("FrameLoss", c.frameStats.String())
 		return
 	}
 
-	// Ensure payload length is correct for Codec 2 at 3200 bps (16 bytes)
-	if len(payload) != 16 {
-		log.Printf("invalid payload length: %d", len(payload))
-		updateTUI("Error", fmt.Sprintf("invalid payload length: %d", len(payload)))
-		updateGUI("Error", fmt.Sprintf("invalid payload length: %d", len(payload)))
+	// Log packet fields
+	slog.Debug("Received M17 packet", "stream_id", formatHex(streamID), "frame_number", formatHex(frameNumber),
+		"dst", dst, "src", src, "type", formatHex(typ), "meta", fmt.Sprintf("%x", meta),
+		"packet_stream_indicator", packetStreamIndicator, "data_type_indicator", dataTypeIndicator, "encryption_type", encryptionType,
+		"encryption_subtype", encryptionSubtype, "channel_access_number", channelAccessNumber)
+
+	// Update the UI fields
+	updateField("StreamID", formatHex(streamID))
+	updateField("FrameNumber", formatHex(frameNumber))
+	updateField("DST", formatDestination(dst))
+	updateField("SRC", src)
+	updateField("TYPE", formatHex(typ))
+	updateField("TypeFlags", describeType(frame.LSF.Type))
+	updateField("META", fmt.Sprintf("%x", meta))
+	updateField("Payload", fmt.Sprintf("%x", payload))
+	updateField("PacketStreamIndicator", fmt.Sprintf("%d", packetStreamIndicator))
+	updateField("DataTypeIndicator", fmt.Sprintf("%d", dataTypeIndicator))
+	updateField("EncryptionType", fmt.Sprintf("%d", encryptionType))
+	updateField("EncryptionSubtype", fmt.Sprintf("%d", encryptionSubtype))
+	updateField("ChannelAccessNumber", formatCAN(channelAccessNumber))
+
+	// A different source reusing the stream ID of the active stream, from a
+	// collision or a misbehaving gateway, starts a new stream
+	if c.stream != nil && c.stream.StreamID == streamID && c.stream.SRC != src {
+		c.restartStream(src)
+	}
+
+	// Track the transmission and reset the stream once the last frame has
+	// been handled
+	newStream := c.stream == nil || c.stream.StreamID != streamID
+	if newStream {
+		c.saveLSF(streamID, packet[6:34])
+	}
+	c.trackStream(streamID, src, dst, eos)
+	c.frameStats.add(streamID, frameNumber)
+	updateField("FrameLoss", c.frameStats.String())
+	updateField("FrameRate", c.frameStats.rate())
+	if eos {
+		defer c.endStream()
+	}
+
+	// Decode position data from the META field
+	position := ""
+	if encryptionType == 0 && encryptionSubtype == m17.MetaGNSS {
+		if pos, ok := decodeGNSS(meta); ok {
+			position = pos
+		}
+	}
+	updateField("Position", position)
+
+	// Decode text data from the META field, which may span several frames
+	if encryptionType == 0 && encryptionSubtype == m17.MetaText {
+		text := c.metaText.add(streamID, meta)
+		updateField("Text", text)
+	}
+
+	// Show the AES-CTR nonce of AES encrypted streams for protocol analysis,
+	// whether or not there is a key to decrypt them
+	nonce := ""
+	if encryptionType == m17.EncryptionAES {
+		if n, ok := parseNonce(meta); ok {
+			nonce = fmt.Sprintf("%x", n)
+		} else {
+			slog.Debug("META too short for an AES nonce", "stream_id", formatHex(streamID), "length", len(meta))
+		}
+	}
+	updateField("Nonce", nonce)
+
+	// Decrypt encrypted frames when possible, otherwise label the stream and
+	// skip decoding
+	encrypted := ""
+	if encryptionType != m17.EncryptionNone {
+		plain, status, ok := c.decrypt(encryptionType, encryptionSubtype, meta, streamID, frameNumber, payload)
+		encrypted = "Encrypted: " + status
+		if newStream {
+			slog.Info("Encrypted stream", "src", src, "status", status)
+		}
+		if !ok {
+			updateField("Encrypted", encrypted)
+			updateField("Status", "Encrypted stream, not decoded")
+			return
+		}
+		payload = plain
+	}
+	updateField("Encrypted", encrypted)
+
+	switch classifyFrame(frame.LSF.Type) {
+	case framePacket:
+		// Packet mode frames carry data such as text messages instead of voice
+		c.handlePacketMode(streamID, frameNumber, src, dst, payload)
+		return
+	case frameNonVoice:
+		slog.Debug("Ignoring non-voice packet", "type", typ)
+		updateField("Status", fmt.Sprintf("Ignoring non-voice packet: TYPE=%d", typ))
+		return
+	}
+
+	// Only play the monitored sources, other streams are still shown and
+	// counted
+	if len(c.onlySRC) > 0 && !c.onlySRC.matches(src) {
+		if newStream {
+			slog.Info("Not playing stream from unmonitored source", "src", src)
+		}
+		updateField("Status", fmt.Sprintf("Not playing %s, not in --only-src", src))
 		return
 	}
 
-	// Decode and play the voice stream using Codec 2
-	audio1, err := c.codec2.Decode(payload[:8])
+	// Start from fresh decoders when a new stream takes over without the
+	// previous one ending, e.g. when its last frame was lost
+	if streamID != c.lastStreamID {
+		c.resetDecoders()
+		c.lastStreamID = streamID
+	}
+
+	// Pick the Codec 2 decoder for this stream
+	decoder, err := c.decoderFor(c.detectCodecMode(dataTypeIndicator))
 	if err != nil {
-		log.Printf("failed to decode first voice frame: %v", err)
-		updateTUI("Error", fmt.Sprintf("failed to decode first voice frame: %v", err))
-		updateGUI("Error", fmt.Sprintf("failed to decode first voice frame: %v", err))
+		slog.Error("failed to initialize codec2", "err", err)
+		updateField("Error", fmt.Sprintf("failed to initialize codec2: %v", err))
 		return
 	}
+	updateField("CodecMode", fmt.Sprintf("%d bps", decoder.Bitrate()))
 
-	audio2, err := c.codec2.Decode(payload[8:])
+	// Split the payload into frames for the selected Codec 2 mode
+	codecFrames, err := splitVoicePayload(payload, decoder.BytesPerFrame(), decoder.SamplesPerFrame())
 	if err != nil {
-		log.Printf("failed to decode second voice frame: %v", err)
-		updateTUI("Error", fmt.Sprintf("failed to decode second voice frame: %v", err))
-		updateGUI("Error", fmt.Sprintf("failed to decode second voice frame: %v", err))
+		c.decodeError(fmt.Errorf("invalid payload: %w", err))
 		return
 	}
 
-	// Combine the two audio frames
-	audio := append(audio1, audio2...)
+	// Decode the voice stream using Codec 2
+	var audio []int16
+	for i, codecFrame := range codecFrames {
+		samples, err := decoder.Decode(codecFrame)
+		if err != nil {
+			c.decodeError(fmt.Errorf("failed to decode voice frame %d: %w", i+1, err))
+			return
+		}
+		audio = append(audio, samples...)
+	}
+	c.metrics.frameDecoded()
 
-	// Play the audio
+	// Play the audio, going through the jitter buffer when enabled
+	if c.jitter != nil {
+		c.jitter.push(streamID, frameNumber, audio)
+		return
+	}
+	c.concealLost(frameNumber)
 	c.playAudio(audio)
+	c.conceal.played(audio)
 }
 
-// playAudio plays audio using the Oto player
-func (c *Client) playAudio(audio []int16) {
-	// Convert int16 audio to byte slice
-	buf := make([]byte, len(audio)*2)
-	for i, sample := range audio {
-		binary.LittleEndian.PutUint16(buf[i*2:], uint16(sample))
+// decodeError records an error decoding a received frame. With
+// --debug-frames every error is logged and shown, otherwise
+// reportDecodeErrors summarizes them so a lossy link doesn't flood the log
+// and the Error field.
+func (c *Client) decodeError(err error) {
+	if c.debugFrames {
+		slog.Warn("failed to decode frame", "err", err)
+		updateField("Error", err.Error())
+		return
+	}
+	c.decodeErrs.add(err)
+}
+
+// reportDecodeErrors logs and shows the number of decode errors every
+// decodeErrorInterval while there are any
+func (c *Client) reportDecodeErrors(ctx context.Context) {
+	ticker := time.NewTicker(decodeErrorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		count, latest := c.decodeErrs.take()
+		if count == 0 {
+			continue
+		}
+		summary := fmt.Sprintf("%d decode errors in last %s", count, decodeErrorInterval)
+		if count == 1 {
+			summary = fmt.Sprintf("1 decode error in last %s", decodeErrorInterval)
+		}
+		slog.Warn("decode errors", "count", count, "interval", decodeErrorInterval, "latest", latest)
+		updateField("Error", fmt.Sprintf("%s, latest: %v", summary, latest))
 	}
+}
+
+// frameKind is how handleM17 treats a frame that passed its CRC check
+type frameKind int
 
-	// Write audio to player
-	_, err := c.player.Write(buf)
+const (
+	frameVoice    frameKind = iota // Voice or voice + data, decoded and played
+	framePacket                    // Packet mode data
+	frameNonVoice                  // Stream mode without voice, ignored
+)
+
+// classifyFrame decides how a frame is handled from its Type field
+func classifyFrame(typ m17.Type) frameKind {
+	if typ.PacketStreamIndicator() == 0 {
+		return framePacket
+	}
+	switch typ.DataTypeIndicator() {
+	case m17.DataTypeVoice, m17.DataTypeVoiceData:
+		return frameVoice
+	}
+	return frameNonVoice
+}
+
+// splitVoicePayload splits the payload of a voice frame into Codec 2 frames.
+// An M17 stream frame carries 40ms of audio, which is either two 20ms Codec 2
+// frames (3200, 2400) or a single 40ms block (1600).
+func splitVoicePayload(payload []byte, frameBytes, frameSamples int) ([][]byte, error) {
+	count := m17FrameSamples / frameSamples
+	if len(payload) < frameBytes*count {
+		return nil, fmt.Errorf("payload too short: %d bytes, need %d", len(payload), frameBytes*count)
+	}
+	frames := make([][]byte, count)
+	for i := range frames {
+		frames[i] = payload[i*frameBytes : (i+1)*frameBytes]
+	}
+	return frames, nil
+}
+
+// concealLost plays concealment for the frames lost before frameNumber when
+// playing without the jitter buffer, which conceals them itself
+func (c *Client) concealLost(frameNumber uint16) {
+	seq := frameNumber & m17.FrameNumberMask
+	if c.sequencing {
+		// A late frame is played as is without moving the expected frame
+		// back
+		if frameBefore(seq, c.nextFrame) {
+			return
+		}
+		lost := int((seq - c.nextFrame) & m17.FrameNumberMask)
+		if lost <= maxConcealFrames {
+			for i := 0; i < lost; i++ {
+				c.playAudio(c.conceal.conceal())
+			}
+		}
+	}
+	c.nextFrame = (seq + 1) & m17.FrameNumberMask
+	c.sequencing = true
+}
+
+// trackStream updates the current transmission record, recording it to the
+// activity log when a new stream starts or the end of stream frame arrives
+func (c *Client) trackStream(streamID uint16, src, dst string, eos bool) {
+	now := time.Now()
+	if c.stream != nil && c.stream.StreamID != streamID {
+		c.finishStream()
+	}
+	if c.stream == nil {
+		c.metrics.streamStarted(src)
+		c.httpStream.streamStarted(src, dst)
+		c.stream = &activityRecord{
+			Start:    now,
+			SRC:      src,
+			DST:      dst,
+			StreamID: streamID,
+			Module:   strings.TrimSpace(string(c.module())),
+		}
+		current := *c.stream
+		c.current.Store(&current)
+		c.sendStreamEvent(WebhookStreamStart, now)
+		jsonEvents.emit(streamEvent(EventStreamStart, current, now))
+		if c.watch.matches(src) {
+			c.alertWatched(now)
+		}
+	}
+	c.stream.End = now
+	c.stream.Frames++
+	elapsed := c.stream.End.Sub(c.stream.Start)
+	updateField("TXDuration", elapsed.Round(time.Second/10).String())
+	if c.maxTalk > 0 && elapsed > c.maxTalk && !c.talkAlerted {
+		c.alertTalkTime(now)
+	}
+	if eos {
+		c.finishStream()
+
+		// Only a stream that ran to its end of stream frame counts as a
+		// complete transmission for --once, not one cut short by a new
+		// stream, a reused stream ID or a disconnect
+		if c.transmitted != nil {
+			c.transmitOnce.Do(func() { close(c.transmitted) })
+		}
+	}
+}
+
+// alertWatched notifies the user that a watched callsign started a stream
+// with a terminal bell, a highlighted status and a webhook event
+func (c *Client) alertWatched(now time.Time) {
+	src := c.stream.SRC
+	slog.Warn("Watched callsign heard", "src", src)
+	fmt.Fprint(os.Stderr, "\a")
+	c.alerting = true
+	setTUIAlert(true)
+	setGUIAlert(true)
+	updateField("Status", fmt.Sprintf("Watched callsign heard: %s", src))
+	c.sendStreamEvent(WebhookWatchHeard, now)
+}
+
+// alertTalkTime notifies the user that the current transmission has run
+// past the talk time limit
+func (c *Client) alertTalkTime(now time.Time) {
+	src := c.stream.SRC
+	slog.Warn("Transmission exceeded the talk time limit", "src", src, "limit", c.maxTalk)
+	fmt.Fprint(os.Stderr, "\a")
+	c.talkAlerted = true
+	updateField("Error", fmt.Sprintf("%s has been transmitting for over %s", src, c.maxTalk))
+	c.sendStreamEvent(WebhookTalkTime, now)
+}
+
+// sendStreamEvent posts an event about the current stream to the webhook
+func (c *Client) sendStreamEvent(event string, timestamp time.Time) {
+	c.webhook.send(webhookEvent{
+		Event:     event,
+		SRC:       c.stream.SRC,
+		DST:       c.stream.DST,
+		StreamID:  c.stream.StreamID,
+		Module:    c.stream.Module,
+		Timestamp: timestamp,
+	})
+}
+
+// finishStream writes the current transmission to the activity log and the
+// GUI history
+func (c *Client) finishStream() {
+	if c.stream == nil {
+		return
+	}
+	slog.Info("Transmission finished", "src", c.stream.SRC, "duration", c.stream.End.Sub(c.stream.Start).Round(time.Second/10))
+	if c.compact != nil {
+		fmt.Fprintln(c.compact, formatCompactLine(*c.stream, c.frameStats.lossPercent()))
+	}
+	addGUIHistory(*c.stream)
+	c.talkAlerted = false
+	c.metrics.streamEnded()
+	c.httpStream.streamEnded()
+	if c.alerting {
+		c.alerting = false
+		setTUIAlert(false)
+		setGUIAlert(false)
+	}
+	c.sendStreamEvent(WebhookStreamEnd, c.stream.End)
+	jsonEvents.emit(streamEndEvent(*c.stream, c.frameStats.lossPercent()))
+	if c.activity != nil {
+		err := c.activity.write(*c.stream)
+		if err == nil {
+			err = c.activity.flush()
+		}
+		if err != nil {
+			slog.Error("failed to write activity log", "err", err)
+			updateField("Error", fmt.Sprintf("failed to write activity log: %v", err))
+		}
+	}
+	c.stream = nil
+	c.current.Store(nil)
+}
+
+// closePlayer closes the audio outputs, letting them finish playing any
+// buffered audio
+func (c *Client) closePlayer() {
+	for _, sink := range c.sinks {
+		if err := sink.Close(); err != nil {
+			slog.Error("failed to close audio output", "sink", sink.name, "err", err)
+		}
+	}
+}
+
+// Close stops the client, waits for the receiver goroutine to finish the
+// active stream, closes the connection, capture file, activity and SMS logs
+// and audio player and logs the frame statistics. It is safe to call more
+// than once, but not from the receiver goroutine.
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		c.closeConnection()
+		c.receivers.Wait()
+		c.webhook.close()
+		c.closeCapture()
+		c.closeActivityLog()
+		c.closeSMSLog()
+		c.closePlayer()
+		c.logFrameStats()
+	})
+}
+
+// closeConnection closes the UDP connection, if connected
+func (c *Client) closeConnection() {
+	if conn := c.connection(); conn != nil {
+		conn.Close()
+	}
+}
+
+// closeCapture closes the capture file
+func (c *Client) closeCapture() {
+	if err := c.capture.Close(); err != nil {
+		slog.Error("failed to close capture file", "err", err)
+	}
+}
+
+// closeActivityLog closes the activity log. Close has already waited for
+// the receiver goroutine to record any transmission still in progress.
+func (c *Client) closeActivityLog() {
+	if c.activity != nil {
+		if err := c.activity.Close(); err != nil {
+			slog.Error("failed to close activity log", "err", err)
+		}
+	}
+}
+
+// closeSMSLog closes the SMS log
+func (c *Client) closeSMSLog() {
+	if err := c.smsLog.Close(); err != nil {
+		slog.Error("failed to close SMS log", "err", err)
+	}
+}
+
+// logFrameStats logs the frame loss over the whole session
+func (c *Client) logFrameStats() {
+	slog.Info("Frame loss", "totals", c.frameStats.totals())
+}
+
+// endStream resets per-stream state after the end of stream frame so the next
+// transmission starts from a clean slate
+func (c *Client) endStream() {
+	slog.Info("End of transmission")
+	updateField("StreamID", "")
+	updateField("FrameNumber", "")
+	updateField("Status", "End of transmission")
+	c.frameStats.end()
+	c.resetDecoders()
+}
+
+// dropStream ends the active stream without waiting for its last frame, when
+// the relay/reflector goes away mid-transmission
+func (c *Client) dropStream() {
+	c.finishStream()
+	c.frameStats.end()
+	c.resetDecoders()
+	if c.jitter != nil {
+		c.jitter.reset()
+	}
+}
+
+// restartStream ends the active stream when a frame from a different source
+// arrives with the same stream ID, so the new source gets fresh decoders and
+// buffers instead of being mixed into the old stream
+func (c *Client) restartStream(src string) {
+	streamID, previous := c.stream.StreamID, c.stream.SRC
+	slog.Warn("stream ID reused by a different source", "stream_id", formatHex(streamID), "src", src, "previous_src", previous)
+	updateField("Error", fmt.Sprintf("stream ID %s reused by %s while %s was transmitting", formatHex(streamID), src, previous))
+
+	c.finishStream()
+	c.frameStats.end()
+	c.resetDecoders()
+	c.metaText = metaText{streamID: streamID}
+	if c.jitter != nil {
+		c.jitter.reset()
+	}
+}
+
+// resetDecoders drops the Codec 2 decoders so the next stream doesn't
+// inherit stale predictor state, they are recreated on demand. Lost frame
+// tracking starts over too.
+func (c *Client) resetDecoders() {
+	for mode, decoder := range c.decoders {
+		decoder.Close()
+		delete(c.decoders, mode)
+	}
+	c.conceal.reset()
+	c.sequencing = false
+}
+
+// detectCodecMode returns the Codec 2 mode to use for a voice stream. Voice
+// only streams carry 3200 bps audio across the whole payload, while voice +
+// data streams carry 1600 bps audio in the first half and data in the second.
+func (c *Client) detectCodecMode(dataTypeIndicator uint16) int {
+	if c.codecMode != codecModeAuto {
+		return c.codecMode
+	}
+	if dataTypeIndicator == m17.DataTypeVoiceData {
+		return codec2.MODE_1600
+	}
+	return codec2.MODE_3200
+}
+
+// decoderFor returns the cached Codec 2 decoder for the given mode, creating
+// it on first use
+func (c *Client) decoderFor(mode int) (*codec2.Codec2, error) {
+	if decoder, ok := c.decoders[mode]; ok {
+		return decoder, nil
+	}
+	decoder, err := codec2.New(mode)
 	if err != nil {
-		log.Printf("failed to play audio: %v", err)
-		updateTUI("Error", fmt.Sprintf("failed to play audio: %v", err))
+		return nil, err
+	}
+	c.decoders[mode] = decoder
+	return decoder, nil
+}
+
+// setVolume sets the playback gain, clamped to the supported range
+func (c *Client) setVolume(volume float64) {
+	volume = math.Max(minVolume, math.Min(maxVolume, volume))
+	c.volume.Store(math.Float64bits(volume))
+	updateField("Volume", fmt.Sprintf("%.0f%%", volume*100))
+}
+
+// getVolume returns the playback gain
+func (c *Client) getVolume() float64 {
+	return math.Float64frombits(c.volume.Load())
+}
+
+// clearError clears the error field
+func (c *Client) clearError() {
+	updateField("Error", "")
+}
+
+// toggleMute mutes or unmutes playback and reports whether it is now muted
+func (c *Client) toggleMute() bool {
+	muted := !c.muted.Load()
+	c.setMuted(muted)
+	return muted
+}
+
+// setMuted mutes or unmutes playback. Decoding continues while muted so the
+// UI keeps updating and the decoder state stays coherent.
+func (c *Client) setMuted(muted bool) {
+	c.muted.Store(muted)
+
+	status := "Audio unmuted"
+	if muted {
+		status = "Audio muted"
+	}
+	slog.Info(status)
+	updateField("Status", status)
+}
+
+// requestStop asks the run loop to disconnect and exit. It is safe to call
+// more than once.
+func (c *Client) requestStop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+// fail stops the client because of err, which runClient reports as a
+// failed run after disconnecting through the normal shutdown path. Only the
+// first error is kept.
+func (c *Client) fail(err error) {
+	c.failOnce.Do(func() {
+		slog.Error("client failed", "err", err)
+		updateField("Error", err.Error())
+		c.failErr = err
+	})
+	c.requestStop()
+}
+
+// failure returns the error passed to fail, or nil. It is only meaningful
+// once the stop channel is closed.
+func (c *Client) failure() error {
+	if !c.stopRequested() {
+		return nil
+	}
+	return c.failErr
+}
+
+// stopRequested reports whether requestStop has been called
+func (c *Client) stopRequested() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// audioStatus describes where decoded audio is played, e.g. "speakers, FIFO"
+func (c *Client) audioStatus() string {
+	if c.audioErr != nil {
+		return "Unavailable"
+	}
+	if len(c.sinks) == 0 {
+		return "Off"
+	}
+	names := make([]string, len(c.sinks))
+	for i, sink := range c.sinks {
+		names[i] = sink.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// playAudio filters the audio, applies the volume and queues the audio for every audio output
+func (c *Client) playAudio(audio []int16) {
+	audio = c.filter.process(audio)
+
+	// Meter and stream the audio even when muted to show it is flowing
+	c.meter.add(audio)
+	addGUIAudio(audio)
+	c.httpStream.write(audio)
+
+	// Keep dead air off the outputs, still showing its level
+	open, changed := c.squelch.update(audio)
+	if changed {
+		updateField("Squelch", c.squelch.String())
+	}
+	if !open || c.muted.Load() {
+		return
+	}
+
+	audio = c.applyVolume(audio)
+	for _, sink := range c.sinks {
+		sink.write(audio)
+	}
+}
+
+// applyVolume returns a copy of the audio with the gain applied, clamping
+// to the int16 range to avoid wrap-around distortion
+func (c *Client) applyVolume(audio []int16) []int16 {
+	volume := c.getVolume()
+	scaled := make([]int16, len(audio))
+	for i, sample := range audio {
+		v := math.Round(float64(sample) * volume)
+		scaled[i] = int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, v)))
 	}
+	return scaled
 }
@@ -0,0 +1,141 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"go-m17-listen/m17"
+	"math"
+	"testing"
+)
+
+// TestClassifyFrame checks how frames are handled from their Type field
+func TestClassifyFrame(t *testing.T) {
+	tests := []struct {
+		name string
+		typ  m17.Type
+		want frameKind
+	}{
+		{"voice", 0x0005, frameVoice},
+		{"voice and data", 0x0007, frameVoice},
+		{"data stream", 0x0003, frameNonVoice},
+		{"reserved data type", 0x0001, frameNonVoice},
+		{"packet mode", 0x0000, framePacket},
+		{"packet mode with voice data type", 0x0004, framePacket},
+		{"AES encrypted voice", 0x0015, frameVoice},
+		{"voice with channel access number", 0x0785, frameVoice},
+		{"voice with reserved bits set", 0xF805, frameVoice},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyFrame(tt.typ); got != tt.want {
+				t.Errorf("classifyFrame(%#04x) = %d, want %d", uint16(tt.typ), got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSplitVoicePayload checks the payload of a voice frame is split into
+// the Codec 2 frames of each mode
+func TestSplitVoicePayload(t *testing.T) {
+	payload := make([]byte, 16)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	tests := []struct {
+		name         string
+		payload      []byte
+		frameBytes   int
+		frameSamples int
+		want         [][]byte
+		wantErr      bool
+	}{
+		{
+			// Two 20ms frames of 8 bytes
+			name: "3200", payload: payload, frameBytes: 8, frameSamples: 160,
+			want: [][]byte{payload[0:8], payload[8:16]},
+		},
+		{
+			// Two 20ms frames of 6 bytes, the rest of the payload unused
+			name: "2400", payload: payload, frameBytes: 6, frameSamples: 160,
+			want: [][]byte{payload[0:6], payload[6:12]},
+		},
+		{
+			// A single 40ms frame of 8 bytes in the first half
+			name: "1600", payload: payload, frameBytes: 8, frameSamples: 320,
+			want: [][]byte{payload[0:8]},
+		},
+		{
+			name: "3200 too short", payload: payload[:15], frameBytes: 8, frameSamples: 160,
+			wantErr: true,
+		},
+		{
+			name: "1600 too short", payload: payload[:7], frameBytes: 8, frameSamples: 320,
+			wantErr: true,
+		},
+		{
+			name: "empty", payload: nil, frameBytes: 8, frameSamples: 160,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitVoicePayload(tt.payload, tt.frameBytes, tt.frameSamples)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("splitVoicePayload = %x, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("splitVoicePayload failed: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("splitVoicePayload returned %d frames, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], tt.want[i]) {
+					t.Errorf("frame %d = %x, want %x", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestPlayChimeSpeakersOnly checks the connection chime is only queued on
+// the speakers, leaving raw PCM outputs with received audio alone
+func TestPlayChimeSpeakersOnly(t *testing.T) {
+	stdout := newAudioSink("stdout", writerPlayer{&bytes.Buffer{}}, nil, true)
+	fifo := newAudioSink("FIFO", writerPlayer{&bytes.Buffer{}}, nil, false)
+	speakers := newAudioSink(speakersSink, writerPlayer{&bytes.Buffer{}}, nil, false)
+	c := &Client{sinks: []*audioSink{stdout, fifo, speakers}}
+	c.volume.Store(math.Float64bits(1))
+
+	c.playChime()
+
+	if n := len(stdout.queue); n != 0 {
+		t.Errorf("stdout has %d chime frames queued, want none", n)
+	}
+	if n := len(fifo.queue); n != 0 {
+		t.Errorf("FIFO has %d chime frames queued, want none", n)
+	}
+	if n := len(speakers.queue); n != 1 {
+		t.Errorf("speakers have %d chime frames queued, want 1", n)
+	}
+}
@@ -25,6 +25,7 @@ package codec2
 import "C"
 import (
 	"errors"
+	"fmt"
 	"unsafe"
 )
 
@@ -35,8 +36,29 @@ type Codec2 struct {
 
 const (
 	MODE_3200 = C.CODEC2_MODE_3200
+	MODE_2400 = C.CODEC2_MODE_2400
+	MODE_1600 = C.CODEC2_MODE_1600
 )
 
+// SampleRate is the rate in Hz of the audio Decode returns. Every Codec 2
+// mode, the low bitrate ones such as 700C included, decodes to 8kHz audio.
+const SampleRate = 8000
+
+// Bitrates lists the Codec 2 bitrates ModeFromBitrate supports
+var Bitrates = []int{3200, 2400, 1600}
+
+func ModeFromBitrate(bitrate int) (int, error) {
+	switch bitrate {
+	case 3200:
+		return MODE_3200, nil
+	case 2400:
+		return MODE_2400, nil
+	case 1600:
+		return MODE_1600, nil
+	}
+	return 0, fmt.Errorf("unsupported codec2 bitrate: %d", bitrate)
+}
+
 func New(mode int) (*Codec2, error) {
 	handle := C.codec2_create(C.int(mode))
 	if handle == nil {
@@ -49,6 +71,18 @@ func (c *Codec2) Close() {
 	C.codec2_destroy(c.handle)
 }
 
+func (c *Codec2) Bitrate() int {
+	return int(C.codec2_bits_per_frame(c.handle)) * SampleRate / c.SamplesPerFrame()
+}
+
+func (c *Codec2) SamplesPerFrame() int {
+	return int(C.codec2_samples_per_frame(c.handle))
+}
+
+func (c *Codec2) BytesPerFrame() int {
+	return int(C.codec2_bits_per_frame(c.handle)+7) / 8
+}
+
 func (c *Codec2) Decode(bits []byte) ([]int16, error) {
 	nsam := C.codec2_samples_per_frame(c.handle)
 	nbit := C.codec2_bits_per_frame(c.handle)
@@ -62,3 +96,17 @@ func (c *Codec2) Decode(bits []byte) ([]int16, error) {
 
 	return audio, nil
 }
+
+func (c *Codec2) Encode(audio []int16) ([]byte, error) {
+	nsam := C.codec2_samples_per_frame(c.handle)
+	nbit := C.codec2_bits_per_frame(c.handle)
+
+	if len(audio) != int(nsam) {
+		return nil, errors.New("invalid sample count")
+	}
+
+	bits := make([]byte, (nbit+7)/8)
+	C.codec2_encode(c.handle, (*C.uchar)(unsafe.Pointer(&bits[0])), (*C.short)(unsafe.Pointer(&audio[0])))
+
+	return bits, nil
+}
@@ -0,0 +1,166 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// fileConfig holds the settings loaded from the config file. Keys other than
+// the ones below are the names of command line flags with dashes replaced by
+// underscores, e.g. codec_mode or jitter_ms.
+type fileConfig struct {
+	Address      string
+	ModuleLetter byte
+	UI           string
+	flags        map[string]string
+}
+
+// defaultConfigPath returns the default location of the config file
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "go-m17-listen", "config.toml")
+}
+
+// loadConfig reads and validates the TOML config file at path. A missing file
+// is only an error when the path was given explicitly.
+func loadConfig(path string, explicit bool) (*fileConfig, error) {
+	cfg := &fileConfig{flags: make(map[string]string)}
+	if path == "" {
+		return cfg, nil
+	}
+
+	var values map[string]interface{}
+	if _, err := toml.DecodeFile(path, &values); err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	for key, value := range values {
+		switch key {
+		case "address":
+			address, ok := value.(string)
+			if !ok || address == "" {
+				return nil, fmt.Errorf("invalid address in config file: %v", value)
+			}
+			cfg.Address = address
+		case "module_letter":
+			letter, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid module_letter in config file: %v (must be a single letter A-Z)", value)
+			}
+			module, err := parseModule(letter)
+			if err != nil {
+				return nil, fmt.Errorf("invalid module_letter in config file: %v", err)
+			}
+			cfg.ModuleLetter = module
+		case "ui":
+			ui, ok := value.(string)
+			if !ok || (ui != "tui" && ui != "gui" && ui != "none") {
+				return nil, fmt.Errorf("invalid ui in config file: %v (must be tui, gui or none)", value)
+			}
+			cfg.UI = ui
+		default:
+			name := strings.ReplaceAll(key, "_", "-")
+			if flag.Lookup(name) == nil || name == "config" {
+				return nil, fmt.Errorf("unknown setting in config file: %s", key)
+			}
+			cfg.flags[name] = fmt.Sprint(value)
+		}
+	}
+
+	return cfg, nil
+}
+
+// isFlagSet reports whether a flag was given on the command line or in the
+// config file, once applyFlags has run
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// applyFlags sets the flags from the config file that weren't given on the
+// command line
+func (cfg *fileConfig) applyFlags() error {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	names := make([]string, 0, len(cfg.flags))
+	for name := range cfg.flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if set[name] {
+			continue
+		}
+		if err := flag.Set(name, cfg.flags[name]); err != nil {
+			return fmt.Errorf("invalid %s in config file: %w", strings.ReplaceAll(name, "-", "_"), err)
+		}
+	}
+
+	return nil
+}
+
+// saveConfigValue sets a string setting in an existing config file, keeping
+// the other settings. Nothing is written if the file doesn't exist.
+func saveConfigValue(path, key, value string) error {
+	var values map[string]interface{}
+	if _, err := toml.DecodeFile(path, &values); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	if values == nil {
+		values = make(map[string]interface{})
+	}
+	values[key] = value
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(values); err != nil {
+		return fmt.Errorf("failed to encode config file: %w", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return nil
+}
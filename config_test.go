@@ -0,0 +1,85 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+// TestSaveConfigValue checks a saved setting replaces or adds the key and
+// keeps the other settings
+func TestSaveConfigValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     map[string]interface{}
+	}{
+		{
+			name:     "replaces existing setting",
+			contents: "address = \"m17-m17.example.org\"\ntheme = \"dark\"\njitter_ms = 120\n",
+			want:     map[string]interface{}{"address": "m17-m17.example.org", "theme": "light", "jitter_ms": int64(120)},
+		},
+		{
+			name:     "adds missing setting",
+			contents: "# my settings\nmodule_letter = \"C\"\nverbose = true\n",
+			want:     map[string]interface{}{"module_letter": "C", "verbose": true, "theme": "light"},
+		},
+		{
+			name:     "empty file",
+			contents: "",
+			want:     map[string]interface{}{"theme": "light"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.toml")
+			if err := os.WriteFile(path, []byte(tt.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := saveConfigValue(path, "theme", "light"); err != nil {
+				t.Fatalf("saveConfigValue: %v", err)
+			}
+
+			var got map[string]interface{}
+			if _, err := toml.DecodeFile(path, &got); err != nil {
+				t.Fatalf("saved config doesn't decode: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("saved config = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSaveConfigValueMissingFile checks nothing is created without a config
+// file
+func TestSaveConfigValueMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := saveConfigValue(path, "theme", "dark"); err != nil {
+		t.Fatalf("saveConfigValue: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("config file was created: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// controlStatus is the client state returned by the status command
+type controlStatus struct {
+	State  string         `json:"state"`
+	Module string         `json:"module,omitempty"`
+	Muted  bool           `json:"muted"`
+	Volume float64        `json:"volume"`
+	Stream *controlStream `json:"stream,omitempty"`
+}
+
+// controlStream describes the active stream in the status
+type controlStream struct {
+	SRC      string    `json:"src"`
+	DST      string    `json:"dst"`
+	StreamID uint16    `json:"stream_id"`
+	Start    time.Time `json:"start"`
+}
+
+// controlResponse is the JSON line written in reply to each command
+type controlResponse struct {
+	OK     bool           `json:"ok"`
+	Error  string         `json:"error,omitempty"`
+	Status *controlStatus `json:"status,omitempty"`
+}
+
+// serveControl accepts connections on a Unix domain socket at path until the
+// client is cancelled. Each line received is a command (status, mute, unmute,
+// switch-module <letter> or disconnect) answered with a JSON line.
+func (c *Client) serveControl(path string) {
+	// Replace a socket left behind by a previous run
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		slog.Error("failed to listen on control socket", "err", err)
+		updateField("Error", fmt.Sprintf("failed to listen on control socket: %v", err))
+		return
+	}
+	go func() {
+		<-c.ctx.Done()
+		l.Close()
+	}()
+
+	slog.Info("Listening on control socket", "path", path)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("failed to accept control connection", "err", err)
+			}
+			return
+		}
+		go c.handleControl(conn)
+	}
+}
+
+// handleControl answers the commands sent on one control connection
+func (c *Client) handleControl(conn net.Conn) {
+	defer conn.Close()
+
+	enc := json.NewEncoder(conn)
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if err := enc.Encode(c.runControlCommand(line)); err != nil {
+			return
+		}
+	}
+}
+
+// runControlCommand runs a control command and returns the reply
+func (c *Client) runControlCommand(line string) controlResponse {
+	command, arg, _ := strings.Cut(line, " ")
+	arg = strings.ToUpper(strings.TrimSpace(arg))
+	slog.Debug("Control command", "command", command, "arg", arg)
+
+	switch command {
+	case "status":
+		status := c.controlStatus()
+		return controlResponse{OK: true, Status: &status}
+	case "mute":
+		c.setMuted(true)
+	case "unmute":
+		c.setMuted(false)
+	case "switch-module":
+		module, err := parseModule(arg)
+		if err != nil || module == ' ' {
+			return controlResponse{Error: "switch-module needs a module letter from A to Z"}
+		}
+		if err := c.switchModule(module); err != nil {
+			return controlResponse{Error: err.Error()}
+		}
+	case "disconnect":
+		c.requestStop()
+	default:
+		return controlResponse{Error: fmt.Sprintf("unknown command: %s", command)}
+	}
+	return controlResponse{OK: true}
+}
+
+// controlStatus returns the current client state
+func (c *Client) controlStatus() controlStatus {
+	c.connMu.Lock()
+	state := c.state
+	c.connMu.Unlock()
+
+	status := controlStatus{
+		State:  state,
+		Module: strings.TrimSpace(string(c.module())),
+		Muted:  c.muted.Load(),
+		Volume: c.getVolume(),
+	}
+	if stream := c.current.Load(); stream != nil {
+		status.Stream = &controlStream{SRC: stream.SRC, DST: stream.DST, StreamID: stream.StreamID, Start: stream.Start}
+	}
+	return status
+}
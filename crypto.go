@@ -0,0 +1,188 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"go-m17-listen/m17"
+	"strconv"
+)
+
+// aesKeyLengths maps the AES encryption subtype to its key length in bytes
+var aesKeyLengths = map[uint16]int{
+	0b00: 16,
+	0b01: 24,
+	0b10: 32,
+}
+
+// scramblerWidths maps the scrambler encryption subtype to its LFSR length in
+// bits
+var scramblerWidths = map[uint16]uint{
+	0b00: 8,
+	0b01: 16,
+	0b10: 24,
+}
+
+// scramblerBitsPerFrame is the length of the keystream used by each frame
+const scramblerBitsPerFrame = 128
+
+// parseAESKey parses a hex encoded AES-128, AES-192 or AES-256 key
+func parseAESKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("key must be hex encoded: %w", err)
+	}
+	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		return nil, fmt.Errorf("key must be 16, 24 or 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// parseScramblerKey parses a hex encoded scrambler seed of up to 24 bits
+func parseScramblerKey(s string) (uint32, error) {
+	seed, err := strconv.ParseUint(s, 16, 24)
+	if err != nil {
+		return 0, fmt.Errorf("key must be a hex encoded seed of up to 24 bits: %w", err)
+	}
+	if seed == 0 {
+		return 0, fmt.Errorf("key must not be zero")
+	}
+	return uint32(seed), nil
+}
+
+// encryptionName returns a readable name for an encryption type and subtype
+func encryptionName(encryptionType, encryptionSubtype uint16) string {
+	switch encryptionType {
+	case m17.EncryptionNone:
+		return "None"
+	case m17.EncryptionScrambler:
+		if n, ok := scramblerWidths[encryptionSubtype]; ok {
+			return fmt.Sprintf("Scrambler (%d-bit)", n)
+		}
+		return "Scrambler"
+	case m17.EncryptionAES:
+		if n, ok := aesKeyLengths[encryptionSubtype]; ok {
+			return fmt.Sprintf("AES-%d", n*8)
+		}
+		return "AES"
+	}
+	return "Reserved"
+}
+
+// decryptAES decrypts an AES-CTR encrypted payload. The counter block is the
+// 14-byte nonce carried in the META field followed by the frame number.
+func decryptAES(block cipher.Block, nonce []byte, frameNumber uint16, payload []byte) []byte {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, nonce[:aesNonceLength])
+	binary.BigEndian.PutUint16(iv[14:], frameNumber&m17.FrameNumberMask)
+
+	plain := make([]byte, len(payload))
+	cipher.NewCTR(block, iv).XORKeyStream(plain, payload)
+	return plain
+}
+
+// scrambler generates the keystream of the M17 LFSR scrambler. The keystream
+// runs continuously from the seed at the start of the stream, each frame
+// using the next 128 bits, so the state is kept between frames and only
+// rewound when a frame arrives out of order.
+type scrambler struct {
+	seed     uint32
+	width    uint
+	streamID uint16
+	next     uint16
+	state    uint32
+	started  bool
+}
+
+// step advances the LFSR by one bit and returns the output bit
+func (s *scrambler) step() uint32 {
+	var bit uint32
+	switch s.width {
+	case 8:
+		bit = (s.state >> 7) ^ (s.state >> 5) ^ (s.state >> 4) ^ (s.state >> 3)
+	case 16:
+		bit = (s.state >> 15) ^ (s.state >> 14) ^ (s.state >> 12) ^ (s.state >> 3)
+	case 24:
+		bit = (s.state >> 23) ^ (s.state >> 22) ^ (s.state >> 21) ^ (s.state >> 16)
+	}
+	bit &= 1
+	s.state = (s.state<<1 | bit) & 0xFFFFFF
+	return bit
+}
+
+// descramble XORs a frame's payload with its part of the keystream
+func (s *scrambler) descramble(streamID, frameNumber uint16, width uint, payload []byte) []byte {
+	seq := frameNumber & m17.FrameNumberMask
+	if !s.started || streamID != s.streamID || width != s.width || seq < s.next {
+		s.streamID = streamID
+		s.width = width
+		s.state = s.seed & (1<<width - 1)
+		s.next = 0
+		s.started = true
+	}
+	for ; s.next < seq; s.next++ {
+		for i := 0; i < scramblerBitsPerFrame; i++ {
+			s.step()
+		}
+	}
+
+	plain := make([]byte, len(payload))
+	for i, b := range payload {
+		var k byte
+		for j := 0; j < 8; j++ {
+			k = k<<1 | byte(s.step())
+		}
+		plain[i] = b ^ k
+	}
+	s.next = seq + 1
+	return plain
+}
+
+// decrypt decrypts the payload of an encrypted stream frame, reporting false
+// with a reason when it can't be decrypted
+func (c *Client) decrypt(encryptionType, encryptionSubtype uint16, meta []byte, streamID, frameNumber uint16, payload []byte) ([]byte, string, bool) {
+	name := encryptionName(encryptionType, encryptionSubtype)
+	if encryptionType == m17.EncryptionScrambler {
+		width, ok := scramblerWidths[encryptionSubtype]
+		if !ok {
+			return nil, fmt.Sprintf("%s, not supported", name), false
+		}
+		if c.scrambler == nil {
+			return nil, fmt.Sprintf("%s, no key", name), false
+		}
+		return c.scrambler.descramble(streamID, frameNumber, width, payload), fmt.Sprintf("%s, descrambling", name), true
+	}
+	if encryptionType != m17.EncryptionAES {
+		return nil, fmt.Sprintf("%s, not supported", name), false
+	}
+	if c.aes == nil {
+		return nil, fmt.Sprintf("%s, no key", name), false
+	}
+	if aesKeyLengths[encryptionSubtype] != len(c.aesKey) {
+		return nil, fmt.Sprintf("%s, key is AES-%d", name, len(c.aesKey)*8), false
+	}
+	nonce, ok := parseNonce(meta)
+	if !ok {
+		return nil, fmt.Sprintf("%s, invalid nonce", name), false
+	}
+	return decryptAES(c.aes, nonce, frameNumber, payload), fmt.Sprintf("%s, decrypting", name), true
+}
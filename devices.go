@@ -0,0 +1,95 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// alsaCardsPath lists the sound cards known to ALSA
+const alsaCardsPath = "/proc/asound/cards"
+
+// audioDevice describes an audio output device
+type audioDevice struct {
+	Index string
+	ID    string
+	Name  string
+}
+
+// listAudioDevices returns the available audio output devices. Oto always
+// opens the ALSA "default" device, so devices are the ALSA sound cards which
+// the default device can be pointed at.
+func listAudioDevices() ([]audioDevice, error) {
+	file, err := os.Open(alsaCardsPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errors.New("listing audio devices is only supported with ALSA")
+		}
+		return nil, fmt.Errorf("failed to list audio devices: %w", err)
+	}
+	defer file.Close()
+
+	// Each card starts with a line like " 0 [PCH            ]: HDA-Intel - HDA Intel PCH"
+	var devices []audioDevice
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		open := strings.Index(line, "[")
+		closing := strings.Index(line, "]")
+		if open < 1 || closing < open {
+			continue
+		}
+		index := strings.TrimSpace(line[:open])
+		if index == "" {
+			continue
+		}
+		name := line[closing+1:]
+		if i := strings.Index(name, " - "); i >= 0 {
+			name = name[i+3:]
+		}
+		devices = append(devices, audioDevice{
+			Index: index,
+			ID:    strings.TrimSpace(line[open+1 : closing]),
+			Name:  strings.TrimSpace(name),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list audio devices: %w", err)
+	}
+
+	return devices, nil
+}
+
+// selectAudioDevice points the default ALSA device at the named device. The
+// name may be the card index, ID or full name.
+func selectAudioDevice(name string) error {
+	devices, err := listAudioDevices()
+	if err != nil {
+		return err
+	}
+	for _, device := range devices {
+		if strings.EqualFold(name, device.Index) || strings.EqualFold(name, device.ID) || strings.EqualFold(name, device.Name) {
+			return os.Setenv("ALSA_CARD", device.ID)
+		}
+	}
+	return fmt.Errorf("audio device not found: %s", name)
+}
@@ -0,0 +1,155 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultDirectoryURL is the public list of M17 reflectors
+const defaultDirectoryURL = "https://dvref.com/mrefd/json/?format=json"
+
+// Directory cache settings
+const (
+	directoryCacheFile = "reflectors.json"
+	directoryMaxAge    = 24 * time.Hour
+	directoryTimeout   = 10 * time.Second
+)
+
+// reflectorEntry is a reflector in the directory
+type reflectorEntry struct {
+	Designator string      `json:"designator"`
+	IPv4       string      `json:"ipv4"`
+	IPv6       string      `json:"ipv6"`
+	Port       json.Number `json:"port"`
+}
+
+// reflectorDirectory is the JSON document listing the reflectors
+type reflectorDirectory struct {
+	Reflectors []reflectorEntry `json:"reflectors"`
+}
+
+// isReflectorName reports whether an address is a reflector designator such
+// as M17-USA rather than a host and port
+func isReflectorName(address string) bool {
+	return address != "" && !strings.Contains(address, ":")
+}
+
+// resolveReflector looks up a reflector designator, with or without the M17-
+// prefix, in the directory and returns its host:port. The directory is cached
+// for a day and a stale cache is used when it can't be fetched.
+func resolveReflector(name, directoryURL, network string) (string, error) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "M17-") {
+		name = "M17-" + name
+	}
+
+	data, err := loadDirectory(directoryURL)
+	if err != nil {
+		return "", err
+	}
+	var dir reflectorDirectory
+	if err := json.Unmarshal(data, &dir); err != nil {
+		return "", fmt.Errorf("failed to parse reflector directory: %w", err)
+	}
+
+	for _, r := range dir.Reflectors {
+		if strings.ToUpper(r.Designator) != name {
+			continue
+		}
+		port, err := strconv.Atoi(r.Port.String())
+		if err != nil || port <= 0 || port > 65535 {
+			port = 17000
+		}
+		host := r.IPv4
+		if network == "udp6" || (host == "" && network != "udp4") {
+			host = r.IPv6
+		}
+		if host == "" {
+			return "", fmt.Errorf("reflector %s has no address for %s", name, network)
+		}
+		return net.JoinHostPort(host, strconv.Itoa(port)), nil
+	}
+	return "", fmt.Errorf("reflector %s not found in the directory", name)
+}
+
+// loadDirectory returns the reflector directory, from the cache when it is
+// fresh and fetched from url otherwise
+func loadDirectory(url string) ([]byte, error) {
+	cachePath := ""
+	if dir, err := os.UserCacheDir(); err == nil {
+		cachePath = filepath.Join(dir, "go-m17-listen", directoryCacheFile)
+	}
+
+	var cached []byte
+	if cachePath != "" {
+		if info, err := os.Stat(cachePath); err == nil {
+			cached, _ = os.ReadFile(cachePath)
+			if cached != nil && time.Since(info.ModTime()) < directoryMaxAge {
+				return cached, nil
+			}
+		}
+	}
+
+	data, err := fetchDirectory(url)
+	if err != nil {
+		if cached != nil {
+			slog.Warn("failed to fetch reflector directory, using the cached copy", "err", err)
+			return cached, nil
+		}
+		return nil, err
+	}
+
+	if cachePath != "" {
+		if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err == nil {
+			err = os.WriteFile(cachePath, data, 0644)
+		}
+		if err != nil {
+			slog.Warn("failed to cache reflector directory", "err", err)
+		}
+	}
+	return data, nil
+}
+
+// fetchDirectory downloads the reflector directory
+func fetchDirectory(url string) ([]byte, error) {
+	client := &http.Client{Timeout: directoryTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch reflector directory: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch reflector directory: %s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch reflector directory: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,154 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "math"
+
+// Audio filter settings
+const (
+	defaultFilterCutoff = 300.0 // High-pass cutoff in Hz, below the voice band
+	maxFilterCutoff     = 2000.0
+	gateHoldFrames      = 5 // Frames kept open after the level drops, 200ms
+	squelchHysteresisDB = 6 // How far below the threshold the squelch closes
+)
+
+// audioFilter cleans up decoded audio with a high-pass filter removing
+// low-frequency rumble and an optional noise gate muting frames quieter
+// than a threshold. A nil *audioFilter is valid and passes audio through, so
+// filtering costs nothing when disabled.
+type audioFilter struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+	gateThreshold      float64 // RMS level, 0 disables the gate
+	gateHold           int
+}
+
+// newAudioFilter creates a Butterworth high-pass biquad with the given cutoff
+// in Hz for 8kHz audio, and a noise gate closing below gateDB dBFS, or no
+// gate when gateDB is 0
+func newAudioFilter(cutoff, gateDB float64) *audioFilter {
+	w0 := 2 * math.Pi * cutoff / codecSampleRate
+	alpha := math.Sin(w0) / math.Sqrt2 // Q of 1/sqrt(2)
+	cos := math.Cos(w0)
+	a0 := 1 + alpha
+
+	f := &audioFilter{
+		b0: (1 + cos) / 2 / a0,
+		b1: -(1 + cos) / a0,
+		b2: (1 + cos) / 2 / a0,
+		a1: -2 * cos / a0,
+		a2: (1 - alpha) / a0,
+	}
+	if gateDB != 0 {
+		f.gateThreshold = math.Pow(10, gateDB/20)
+	}
+	return f
+}
+
+// process returns a filtered copy of the audio
+func (f *audioFilter) process(audio []int16) []int16 {
+	if f == nil {
+		return audio
+	}
+
+	out := make([]int16, len(audio))
+	var sum float64
+	for i, sample := range audio {
+		x := float64(sample)
+		y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+		f.x2, f.x1 = f.x1, x
+		f.y2, f.y1 = f.y1, y
+		out[i] = int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(y))))
+		s := y / math.MaxInt16
+		sum += s * s
+	}
+
+	// Keep the gate open for a moment after the level drops so quiet
+	// syllables and word endings aren't cut off
+	if f.gateThreshold > 0 && len(audio) > 0 {
+		if math.Sqrt(sum/float64(len(audio))) >= f.gateThreshold {
+			f.gateHold = gateHoldFrames
+		} else if f.gateHold > 0 {
+			f.gateHold--
+		} else {
+			clear(out)
+		}
+	}
+	return out
+}
+
+// squelch suppresses playback of dead air, such as the continuous noise some
+// gateways transmit. It opens when a frame reaches the threshold and closes
+// again only once a frame drops squelchHysteresisDB below it, so it doesn't
+// chatter around the threshold. A nil *squelch is always open.
+type squelch struct {
+	openLevel  float64 // RMS level
+	closeLevel float64
+	open       bool
+}
+
+// newSquelch creates a closed squelch opening at thresholdDB dBFS
+func newSquelch(thresholdDB float64) *squelch {
+	return &squelch{
+		openLevel:  math.Pow(10, thresholdDB/20),
+		closeLevel: math.Pow(10, (thresholdDB-squelchHysteresisDB)/20),
+	}
+}
+
+// update opens or closes the squelch for the level of a decoded frame,
+// reporting whether it is open and whether that changed
+func (s *squelch) update(audio []int16) (open, changed bool) {
+	if s == nil {
+		return true, false
+	}
+	level := frameRMS(audio)
+	switch {
+	case !s.open && level >= s.openLevel:
+		s.open = true
+		changed = true
+	case s.open && level < s.closeLevel:
+		s.open = false
+		changed = true
+	}
+	return s.open, changed
+}
+
+// String describes the squelch state for display
+func (s *squelch) String() string {
+	switch {
+	case s == nil:
+		return "Off"
+	case s.open:
+		return "Open"
+	default:
+		return "Closed"
+	}
+}
+
+// frameRMS returns the RMS level of a frame relative to full scale
+func frameRMS(audio []int16) float64 {
+	if len(audio) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, sample := range audio {
+		s := float64(sample) / math.MaxInt16
+		sum += s * s
+	}
+	return math.Sqrt(sum / float64(len(audio)))
+}
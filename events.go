@@ -0,0 +1,140 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JSON event types
+const (
+	EventConnected    = "connected"
+	EventStreamStart  = "stream_start"
+	EventStreamEnd    = "stream_end"
+	EventError        = "error"
+	EventDisconnected = "disconnected"
+)
+
+// Disconnection reasons
+const (
+	DisconnectClient = "client" // The client unsubscribed, e.g. on shutdown
+	DisconnectRelay  = "relay"  // The relay/reflector dropped the subscription
+)
+
+// jsonEvent is one line of --json-events output. Fields that don't apply to
+// an event are left out.
+type jsonEvent struct {
+	Event     string       `json:"event"`
+	Timestamp time.Time    `json:"timestamp"`
+	Relay     string       `json:"relay,omitempty"`  // connected, disconnected
+	Module    string       `json:"module,omitempty"` // connected, disconnected
+	Reason    string       `json:"reason,omitempty"` // disconnected
+	Stream    *eventStream `json:"stream,omitempty"` // stream_start, stream_end
+	Stats     *eventStats  `json:"stats,omitempty"`  // stream_end
+	Error     string       `json:"error,omitempty"`  // error
+}
+
+// eventStream describes the transmission a stream event is about
+type eventStream struct {
+	SRC      string `json:"src"`
+	DST      string `json:"dst"`
+	StreamID uint16 `json:"stream_id"`
+	Module   string `json:"module"`
+}
+
+// eventStats summarizes a finished transmission
+type eventStats struct {
+	DurationSeconds float64 `json:"duration_seconds"`
+	Frames          int     `json:"frames"`
+	LossPercent     float64 `json:"loss_percent"`
+}
+
+// eventWriter writes events as newline-delimited JSON. A nil *eventWriter
+// discards events.
+type eventWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// jsonEvents receives the --json-events output, nil when disabled. Like the
+// UI it is shared by the whole program, so errors shown in the Error field
+// become events too.
+var jsonEvents *eventWriter
+
+// newEventWriter creates an event writer writing to w
+func newEventWriter(w io.Writer) *eventWriter {
+	return &eventWriter{enc: json.NewEncoder(w)}
+}
+
+// emit writes an event, stamping it with the current time if it has none
+func (w *eventWriter) emit(ev jsonEvent) {
+	if w == nil {
+		return
+	}
+	if ev.Timestamp.IsZero() {
+		ev.Timestamp = time.Now()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.enc.Encode(ev); err != nil {
+		slog.Debug("failed to write JSON event", "event", ev.Event, "err", err)
+	}
+}
+
+// streamEvent returns an event about a transmission
+func streamEvent(event string, rec activityRecord, timestamp time.Time) jsonEvent {
+	return jsonEvent{
+		Event:     event,
+		Timestamp: timestamp,
+		Stream: &eventStream{
+			SRC:      rec.SRC,
+			DST:      rec.DST,
+			StreamID: rec.StreamID,
+			Module:   rec.Module,
+		},
+	}
+}
+
+// streamEndEvent returns the event for a finished transmission with its
+// duration, frame count and frame loss
+func streamEndEvent(rec activityRecord, lossPercent float64) jsonEvent {
+	ev := streamEvent(EventStreamEnd, rec, rec.End)
+	ev.Stats = &eventStats{
+		DurationSeconds: math.Round(rec.End.Sub(rec.Start).Seconds()*10) / 10,
+		Frames:          rec.Frames,
+		LossPercent:     math.Round(lossPercent*10) / 10,
+	}
+	return ev
+}
+
+// connectionEvent returns a connected or disconnected event for the
+// relay/reflector the client is subscribed to
+func (c *Client) connectionEvent(event, reason string) jsonEvent {
+	return jsonEvent{
+		Event:  event,
+		Relay:  c.host(),
+		Module: strings.TrimSpace(string(c.module())),
+		Reason: reason,
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxCAN is the largest channel access number, which is a 4-bit field
+const maxCAN = 15
+
+// canFilter lists the channel access numbers of the streams to handle. An
+// empty filter handles every stream.
+type canFilter []uint16
+
+// parseCANFilter parses a comma-separated list of channel access numbers
+func parseCANFilter(spec string) (canFilter, error) {
+	var filter canFilter
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		can, err := strconv.ParseUint(field, 10, 16)
+		if err != nil || can > maxCAN {
+			return nil, fmt.Errorf("invalid channel access number %q (must be from 0 to %d)", field, maxCAN)
+		}
+		filter = append(filter, uint16(can))
+	}
+	if len(filter) == 0 {
+		return nil, fmt.Errorf("no channel access numbers given")
+	}
+	return filter, nil
+}
+
+// allows reports whether streams with the given channel access number are
+// handled
+func (f canFilter) allows(can uint16) bool {
+	return len(f) == 0 || slices.Contains(f, can)
+}
+
+// formatCAN formats a channel access number for display. Like a repeater's
+// CTCSS tone it separates logical channels sharing a frequency or module,
+// with 0 used when no channel has been set up.
+func formatCAN(can uint16) string {
+	if can == 0 {
+		return "0 (default)"
+	}
+	return fmt.Sprintf("%d (channel %d)", can, can)
+}
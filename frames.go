@@ -0,0 +1,136 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"go-m17-listen/m17"
+	"log/slog"
+	"strings"
+)
+
+// dataTypeNames maps the data type bits of the Type field to their meaning
+var dataTypeNames = map[uint16]string{
+	0b00: "reserved",
+	0b01: "data",
+	0b10: "voice",
+	0b11: "voice + data",
+}
+
+// metaTypeNames maps the encryption subtype bits of an unencrypted stream to
+// the META field contents they select
+var metaTypeNames = map[uint16]string{
+	m17.MetaText:             "text META",
+	m17.MetaGNSS:             "GNSS META",
+	m17.MetaExtendedCallsign: "extended callsign META",
+	0b11:                     "reserved",
+}
+
+// describeType summarizes the flags of the Type field for display, e.g.
+// "Stream, Voice, CAN 0, Unencrypted"
+func describeType(typ m17.Type) string {
+	mode := "Packet"
+	if typ.PacketStreamIndicator() != 0 {
+		mode = "Stream"
+	}
+	dataType := dataTypeNames[typ.DataTypeIndicator()]
+	dataType = strings.ToUpper(dataType[:1]) + dataType[1:]
+	encryption := "Unencrypted"
+	if typ.EncryptionType() != m17.EncryptionNone {
+		encryption = encryptionName(typ.EncryptionType(), typ.EncryptionSubtype())
+	}
+	return fmt.Sprintf("%s, %s, CAN %d, %s", mode, dataType, typ.ChannelAccessNumber(), encryption)
+}
+
+// frameField is one line of a frame dump
+type frameField struct {
+	key   string // Log attribute key
+	name  string // Display name, indented to show nesting
+	value string
+}
+
+// dumpFrame breaks an M17 stream frame down into every field, including the
+// raw LICH, the full Type bit decomposition and the CRC
+func dumpFrame(packet []byte) []frameField {
+	lich := packet[6:34]
+	typ := binary.BigEndian.Uint16(lich[12:14])
+	frameNumber := binary.BigEndian.Uint16(packet[34:36])
+	crc := binary.BigEndian.Uint16(packet[52:54])
+	computed := m17.CRC16(packet[:52])
+
+	streamMode := "packet"
+	if typ&0x0001 != 0 {
+		streamMode = "stream"
+	}
+	dataType := (typ >> 1) & 0x0003
+	encryptionType := (typ >> 3) & 0x0003
+	encryptionSubtype := (typ >> 5) & 0x0003
+
+	subtype := metaTypeNames[encryptionSubtype]
+	meta := "META"
+	switch encryptionType {
+	case m17.EncryptionAES:
+		subtype = encryptionName(encryptionType, encryptionSubtype)
+		meta = "META (nonce)"
+	case m17.EncryptionScrambler:
+		subtype = encryptionName(encryptionType, encryptionSubtype)
+	}
+
+	eos := ""
+	if frameNumber&m17.FrameNumberEOS != 0 {
+		eos = "EOS, "
+	}
+	crcStatus := "OK"
+	if crc != computed {
+		crcStatus = fmt.Sprintf("bad, computed %s", formatHex(computed))
+	}
+
+	return []frameField{
+		{"magic", "Magic", fmt.Sprintf("%q", packet[0:4])},
+		{"stream_id", "Stream ID", formatHex(binary.BigEndian.Uint16(packet[4:6]))},
+		{"lich", "LICH", fmt.Sprintf("%x", lich)},
+		{"dst", "  DST", fmt.Sprintf("%x (%s)", lich[0:6], m17.DecodeCallsign(lich[0:6]))},
+		{"src", "  SRC", fmt.Sprintf("%x (%s)", lich[6:12], m17.DecodeCallsign(lich[6:12]))},
+		{"type", "  TYPE", fmt.Sprintf("%s (%016b)", formatHex(typ), typ)},
+		{"type_stream", "    bit 0", fmt.Sprintf("%d (%s)", typ&0x0001, streamMode)},
+		{"type_data", "    bits 1-2", fmt.Sprintf("%02b (%s)", dataType, dataTypeNames[dataType])},
+		{"type_encryption", "    bits 3-4", fmt.Sprintf("%02b (%s)", encryptionType, encryptionName(encryptionType, encryptionSubtype))},
+		{"type_subtype", "    bits 5-6", fmt.Sprintf("%02b (%s)", encryptionSubtype, subtype)},
+		{"type_can", "    bits 7-10", fmt.Sprintf("%d (channel access number)", (typ>>7)&0x000F)},
+		{"type_reserved", "    bits 11-15", fmt.Sprintf("%05b (reserved)", typ>>11)},
+		{"meta", "  " + meta, fmt.Sprintf("%x", lich[14:28])},
+		{"frame_number", "Frame Number", fmt.Sprintf("%s (%sframe %d)", formatHex(frameNumber), eos, frameNumber&m17.FrameNumberMask)},
+		{"payload", "Payload", fmt.Sprintf("%x", packet[36:52])},
+		{"crc", "CRC", fmt.Sprintf("%s (%s)", formatHex(crc), crcStatus)},
+	}
+}
+
+// logFrame writes a frame dump to the log and the frame details pane
+func logFrame(packet []byte) {
+	fields := dumpFrame(packet)
+
+	attrs := make([]any, 0, len(fields)*2)
+	lines := make([]string, 0, len(fields))
+	for _, f := range fields {
+		attrs = append(attrs, f.key, f.value)
+		lines = append(lines, fmt.Sprintf("%-14s %s", f.name+":", f.value))
+	}
+	slog.Info("Frame", attrs...)
+	updateField("FrameDetails", strings.Join(lines, "\n"))
+}
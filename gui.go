@@ -2,25 +2,103 @@ package main
 
 import (
 	"fmt"
+	"image"
+	"strings"
+	"sync"
+	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
+	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 )
 
 // guiLabels stores the GUI labels
 var guiLabels map[string]*widget.Label
 
-// startGUI starts the GUI
-func startGUI() {
+// guiPending stores label updates waiting to be applied by the GUI updater,
+// keeping only the latest value of each field
+var guiPending = make(map[string]string)
+
+// guiDirty signals the GUI updater that guiPending has updates
+var guiDirty = make(chan struct{}, 1)
+
+// guiValues stores the latest value of each field, before any timestamp is
+// added
+var guiValues = make(map[string]string)
+
+// guiErrorChanged stores when the Error field last changed, zero when there
+// is no error
+var guiErrorChanged time.Time
+
+// guiPendingStale stores whether the Error field should be grayed out as
+// stale, or nil if unchanged
+var guiPendingStale *bool
+
+// guiLevel shows the audio level
+var guiLevel *widget.ProgressBar
+
+// guiPendingLevel stores the audio level waiting to be applied, or -1
+var guiPendingLevel = -1.0
+
+// guiScope stores the audio history shown by the waveform and spectrogram
+var guiScope *audioScope
+
+// guiScopeRasters show guiScope
+var guiScopeRasters []*canvas.Raster
+
+// guiPendingScope stores whether guiScope has new audio to show
+var guiPendingScope bool
+
+// guiTitle is the title of the GUI window
+const guiTitle = "M17 Listen Client"
+
+// guiFlashDuration is how long flashGUI marks the window title
+const guiFlashDuration = 3 * time.Second
+
+// guiWindow is the GUI window
+var guiWindow fyne.Window
+
+// guiPendingFlash stores whether the window title should be marked as
+// connected, or nil if unchanged
+var guiPendingFlash *bool
+
+// guiPendingAlert stores whether the watched callsign highlight should be
+// shown, or nil if unchanged
+var guiPendingAlert *bool
+
+// guiHistoryLimit is the number of transmissions kept in the history list
+const guiHistoryLimit = 100
+
+// guiHistory stores recent transmissions, newest first
+var guiHistory []activityRecord
+
+// guiHistoryList shows guiHistory
+var guiHistoryList *widget.List
+
+// guiHistoryMu guards guiHistory
+var guiHistoryMu sync.Mutex
+
+// guiPendingHistory stores transmissions waiting to be added to the history
+var guiPendingHistory []activityRecord
+
+// guiMu guards guiLabels, guiLevel, guiScope, guiScopeRasters, guiWindow,
+// guiHistoryList, guiValues, guiErrorChanged and the pending updates
+var guiMu sync.Mutex
+
+// startGUI starts the GUI using the given theme variant. Toggling the theme
+// saves the choice to the config file at configPath if it exists.
+func startGUI(client *Client, themeVariant, configPath string) {
 	// Create a new application
 	a := app.New()
-	a.Settings().SetTheme(&customTheme{})
-	w := a.NewWindow("M17 Listen Client")
+	a.Settings().SetTheme(&customTheme{variant: themeVariant})
+	w := a.NewWindow(guiTitle)
 
 	// Initialize the map of GUI labels
-	guiLabels = make(map[string]*widget.Label)
+	labels := make(map[string]*widget.Label)
 
 	// Create the GUI content
 	content := container.NewVBox(
@@ -30,26 +108,44 @@ func startGUI() {
 	// Field names and their display names
 	fields := map[string]string{
 		"Status":                "Status",
+		"Module":                "Module",
+		"Volume":                "Volume",
+		"LinkHealth":            "Link Health",
+		"RTT":                   "Round Trip",
+		"Squelch":               "Squelch",
+		"Audio":                 "Audio",
+		"Relay":                 "Relay",
 		"StreamID":              "Stream ID",
 		"FrameNumber":           "Frame Number",
 		"DST":                   "Destination",
 		"SRC":                   "Source",
 		"TYPE":                  "Type",
+		"TypeFlags":             "Type Flags",
 		"META":                  "Metadata",
+		"Position":              "Position",
+		"Text":                  "Text",
+		"Message":               "Message",
+		"Encrypted":             "Encryption",
+		"Nonce":                 "Nonce",
 		"PacketStreamIndicator": "Packet Stream Indicator",
 		"DataTypeIndicator":     "Data Type Indicator",
 		"EncryptionType":        "Encryption Type",
 		"EncryptionSubtype":     "Encryption Subtype",
 		"ChannelAccessNumber":   "Channel Access Number",
+		"CodecMode":             "Codec Mode",
+		"FrameLoss":             "Frame Loss",
+		"FrameRate":             "Frame Rate",
+		"TXDuration":            "TX Duration",
 		"Payload":               "Payload",
+		"CRCFailures":           "CRC Failures",
 		"Error":                 "Error",
 	}
 
 	// Field order
 	fieldOrder := []string{
-		"Status", "StreamID", "FrameNumber", "DST", "SRC", "TYPE", "META",
+		"Status", "Relay", "Module", "Volume", "Audio", "Squelch", "LinkHealth", "RTT", "StreamID", "FrameNumber", "TXDuration", "DST", "SRC", "TYPE", "TypeFlags", "META", "Position", "Text", "Message", "Encrypted", "Nonce",
 		"PacketStreamIndicator", "DataTypeIndicator", "EncryptionType",
-		"EncryptionSubtype", "ChannelAccessNumber", "Payload", "Error",
+		"EncryptionSubtype", "ChannelAccessNumber", "CodecMode", "FrameLoss", "FrameRate", "Payload", "CRCFailures", "Error",
 	}
 
 	// Create a grid to display the fields
@@ -61,7 +157,25 @@ func startGUI() {
 		if field == "Error" {
 			value.SetText("None")
 		}
-		guiLabels[field] = value
+		if field == "CRCFailures" {
+			value.SetText("0")
+		}
+		if field == "Module" {
+			value.SetText(strings.TrimSpace(string(client.module())))
+		}
+		if field == "Volume" {
+			value.SetText(fmt.Sprintf("%.0f%%", client.getVolume()*100))
+		}
+		if field == "Relay" {
+			value.SetText(client.host())
+		}
+		if field == "Audio" {
+			value.SetText(client.audioStatus())
+		}
+		if field == "Squelch" {
+			value.SetText(client.squelch.String())
+		}
+		labels[field] = value
 		grid.Add(label)
 		grid.Add(value)
 	}
@@ -69,19 +183,348 @@ func startGUI() {
 	// Add the grid to the content
 	content.Add(grid)
 
+	// Add a pane showing every field of the latest frame when requested
+	if client.debugFrames {
+		details := widget.NewLabelWithStyle("", fyne.TextAlignLeading, fyne.TextStyle{Monospace: true})
+		labels["FrameDetails"] = details
+		content.Add(widget.NewAccordion(widget.NewAccordionItem("Frame Details", details)))
+	}
+
+	// Add a pane listing received text messages, newest first
+	messages := widget.NewLabelWithStyle("No text messages yet", fyne.TextAlignLeading, fyne.TextStyle{Monospace: true})
+	messages.Wrapping = fyne.TextWrapWord
+	labels["Messages"] = messages
+	content.Add(widget.NewAccordion(widget.NewAccordionItem("Text Messages", messages)))
+
+	// Add a meter showing the audio level
+	level := widget.NewProgressBar()
+	level.TextFormatter = func() string { return "" }
+	content.Add(container.NewBorder(nil, nil,
+		widget.NewLabelWithStyle("Level:", fyne.TextAlignLeading, fyne.TextStyle{Monospace: true}), nil, level))
+
+	// Add a scrolling waveform and spectrogram of the decoded audio
+	scope := newAudioScope()
+	waveform := canvas.NewRaster(func(w, h int) image.Image {
+		return scope.waveform(w, h, theme.Color(theme.ColorNamePrimary))
+	})
+	waveform.SetMinSize(fyne.NewSize(0, 60))
+	spectrogram := canvas.NewRaster(func(w, h int) image.Image {
+		return scope.spectrogram(w, h, theme.Color(theme.ColorNamePrimary))
+	})
+	spectrogram.SetMinSize(fyne.NewSize(0, 80))
+	scopeItem := widget.NewAccordionItem("Audio Scope", container.NewVBox(waveform, spectrogram))
+	scopeItem.Open = true
+	content.Add(widget.NewAccordion(scopeItem))
+
+	// Add a slider to adjust the playback volume and a mute button
+	volume := widget.NewSlider(minVolume, maxVolume)
+	volume.Step = 0.05
+	volume.SetValue(client.getVolume())
+	volume.OnChanged = client.setVolume
+	var mute *widget.Button
+	mute = widget.NewButton("Mute", func() {
+		if client.toggleMute() {
+			mute.SetText("Unmute")
+		} else {
+			mute.SetText("Mute")
+		}
+	})
+	content.Add(container.NewBorder(nil, nil,
+		widget.NewLabelWithStyle("Volume:", fyne.TextAlignLeading, fyne.TextStyle{Monospace: true}), mute, volume))
+
+	// Add a button to toggle between the light and dark theme
+	content.Add(widget.NewButton("Toggle Light/Dark Theme", func() {
+		themeVariant = toggledTheme(themeVariant, a.Settings().ThemeVariant())
+		a.Settings().SetTheme(&customTheme{variant: themeVariant})
+		if err := saveConfigValue(configPath, "theme", themeVariant); err != nil {
+			updateGUI("Error", err.Error())
+		}
+	}))
+
+	// Create a list of recent transmissions, showing details when clicked
+	history := widget.NewList(
+		func() int {
+			guiHistoryMu.Lock()
+			defer guiHistoryMu.Unlock()
+			return len(guiHistory)
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabelWithStyle("", fyne.TextAlignLeading, fyne.TextStyle{Monospace: true})
+		},
+		func(id widget.ListItemID, item fyne.CanvasObject) {
+			guiHistoryMu.Lock()
+			defer guiHistoryMu.Unlock()
+			if id < len(guiHistory) {
+				rec := guiHistory[id]
+				item.(*widget.Label).SetText(fmt.Sprintf("%s  %-9s > %-9s  %s",
+					rec.Start.Format("15:04:05"), rec.SRC, rec.DST, rec.End.Sub(rec.Start).Round(time.Second/10)))
+			}
+		},
+	)
+	history.OnSelected = func(id widget.ListItemID) {
+		guiHistoryMu.Lock()
+		if id >= len(guiHistory) {
+			guiHistoryMu.Unlock()
+			return
+		}
+		rec := guiHistory[id]
+		guiHistoryMu.Unlock()
+
+		details := fmt.Sprintf("Source: %s\nDestination: %s\nStream ID: %s\nStart: %s\nEnd: %s\nDuration: %s\nFrames: %d",
+			rec.SRC, rec.DST, formatHex(rec.StreamID), rec.Start.Format(time.DateTime), rec.End.Format(time.DateTime),
+			rec.End.Sub(rec.Start).Round(time.Second/10), rec.Frames)
+		if rec.Module != "" {
+			details += fmt.Sprintf("\nModule: %s", rec.Module)
+		}
+		dialog.ShowInformation("Transmission", details, w)
+		history.UnselectAll()
+	}
+	historyPanel := container.NewBorder(
+		widget.NewLabelWithStyle("Recent Transmissions:", fyne.TextAlignLeading, fyne.TextStyle{Monospace: true}),
+		nil, nil, nil, history)
+
+	// Publish the widgets for updateGUI and start applying updates
+	guiMu.Lock()
+	guiLabels = labels
+	guiLevel = level
+	guiScope = scope
+	guiScopeRasters = []*canvas.Raster{waveform, spectrogram}
+	guiWindow = w
+	guiHistoryList = history
+	guiMu.Unlock()
+	go applyGUIUpdates()
+	go watchGUIStaleError()
+
 	// Set the content and show the window
-	w.SetContent(content)
-	w.Resize(fyne.NewSize(400, 400))
+	w.SetContent(container.NewBorder(content, nil, nil, nil, historyPanel))
+	w.Resize(fyne.NewSize(400, 850))
 	w.ShowAndRun()
 }
 
-// updateGUI updates the GUI field with the given value
+// updateGUI updates the GUI field with the given value, showing an empty
+// error as None and prefixing Status and Error with the time they last
+// changed. It is safe to call from multiple goroutines, the update is
+// queued and applied to the label by applyGUIUpdates so network goroutines
+// never touch widgets directly.
 func updateGUI(field, status string) {
-	if label, ok := guiLabels[field]; ok {
-		if field == "StreamID" || field == "FrameNumber" || field == "TYPE" {
-			label.SetText(fmt.Sprintf("0x%s", status))
-		} else {
-			label.SetText(status)
+	if field == "Error" && status == "" {
+		status = "None"
+	}
+
+	guiMu.Lock()
+	if _, ok := guiLabels[field]; !ok {
+		guiMu.Unlock()
+		return
+	}
+	changed := status != guiValues[field]
+	guiValues[field] = status
+	if isTimestamped(field, status) {
+		if changed {
+			guiPending[field] = formatTimestamped(status, time.Now())
+		}
+	} else {
+		guiPending[field] = status
+	}
+
+	// Show a changed error as current until watchGUIStaleError grays it out
+	if field == "Error" && changed {
+		guiErrorChanged = time.Time{}
+		if status != "None" {
+			guiErrorChanged = time.Now()
+		}
+		stale := false
+		guiPendingStale = &stale
+	}
+	guiMu.Unlock()
+
+	signalGUIUpdate()
+}
+
+// updateGUILevel updates the audio level meter. It is safe to call from
+// multiple goroutines.
+func updateGUILevel(level float64) {
+	guiMu.Lock()
+	if guiLevel == nil {
+		guiMu.Unlock()
+		return
+	}
+	guiPendingLevel = level
+	guiMu.Unlock()
+
+	signalGUIUpdate()
+}
+
+// addGUIAudio adds decoded audio to the waveform and spectrogram. It is safe
+// to call from multiple goroutines.
+func addGUIAudio(audio []int16) {
+	guiMu.Lock()
+	scope := guiScope
+	guiMu.Unlock()
+	if scope == nil {
+		return
+	}
+	scope.add(audio)
+
+	guiMu.Lock()
+	guiPendingScope = true
+	guiMu.Unlock()
+
+	signalGUIUpdate()
+}
+
+// flashGUI marks the window title for guiFlashDuration to confirm the
+// relay/reflector accepted the connection. It is safe to call from multiple
+// goroutines.
+func flashGUI() {
+	setGUIFlash(true)
+	time.AfterFunc(guiFlashDuration, func() { setGUIFlash(false) })
+}
+
+// setGUIFlash queues marking or restoring the window title
+func setGUIFlash(flash bool) {
+	guiMu.Lock()
+	if guiWindow == nil {
+		guiMu.Unlock()
+		return
+	}
+	guiPendingFlash = &flash
+	guiMu.Unlock()
+
+	signalGUIUpdate()
+}
+
+// setGUIAlert highlights the Status and Source fields while a stream from a
+// watched callsign is active. It is safe to call from multiple goroutines.
+func setGUIAlert(alert bool) {
+	guiMu.Lock()
+	if guiLabels == nil {
+		guiMu.Unlock()
+		return
+	}
+	guiPendingAlert = &alert
+	guiMu.Unlock()
+
+	signalGUIUpdate()
+}
+
+// addGUIHistory adds a finished transmission to the history list. It is safe
+// to call from multiple goroutines.
+func addGUIHistory(rec activityRecord) {
+	guiMu.Lock()
+	if guiHistoryList == nil {
+		guiMu.Unlock()
+		return
+	}
+	guiPendingHistory = append(guiPendingHistory, rec)
+	guiMu.Unlock()
+
+	signalGUIUpdate()
+}
+
+// watchGUIStaleError grays out the Error field once it has been unchanged
+// for errorStaleAfter
+func watchGUIStaleError() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		guiMu.Lock()
+		if guiErrorChanged.IsZero() || time.Since(guiErrorChanged) < errorStaleAfter {
+			guiMu.Unlock()
+			continue
+		}
+		guiErrorChanged = time.Time{}
+		stale := true
+		guiPendingStale = &stale
+		guiMu.Unlock()
+
+		signalGUIUpdate()
+	}
+}
+
+// signalGUIUpdate wakes the updater without blocking if it is already
+// signalled
+func signalGUIUpdate() {
+	select {
+	case guiDirty <- struct{}{}:
+	default:
+	}
+}
+
+// applyGUIUpdates applies queued label updates from a single goroutine,
+// coalescing rapid updates to the same field. Each batch runs on the Fyne UI
+// thread, and waiting for it lets updates queued meanwhile coalesce.
+func applyGUIUpdates() {
+	for range guiDirty {
+		fyne.DoAndWait(applyGUIPending)
+	}
+}
+
+// applyGUIPending applies the queued updates to the widgets. It must run on
+// the Fyne UI thread.
+func applyGUIPending() {
+	guiMu.Lock()
+	pending := guiPending
+	guiPending = make(map[string]string)
+	labels := guiLabels
+	level := guiPendingLevel
+	guiPendingLevel = -1
+	alert := guiPendingAlert
+	guiPendingAlert = nil
+	history := guiPendingHistory
+	guiPendingHistory = nil
+	stale := guiPendingStale
+	guiPendingStale = nil
+	scoped := guiPendingScope
+	guiPendingScope = false
+	flash := guiPendingFlash
+	guiPendingFlash = nil
+	guiMu.Unlock()
+
+	for field, status := range pending {
+		labels[field].SetText(status)
+	}
+	if level >= 0 {
+		guiLevel.SetValue(level)
+	}
+	if flash != nil {
+		title := guiTitle
+		if *flash {
+			title = "✔ Connected - " + guiTitle
+		}
+		guiWindow.SetTitle(title)
+	}
+	if scoped {
+		for _, raster := range guiScopeRasters {
+			raster.Refresh()
+		}
+	}
+	if alert != nil {
+		importance := widget.MediumImportance
+		if *alert {
+			importance = widget.WarningImportance
+		}
+		for _, field := range []string{"Status", "SRC"} {
+			labels[field].Importance = importance
+			labels[field].Refresh()
+		}
+	}
+	if stale != nil {
+		importance := widget.MediumImportance
+		if *stale {
+			importance = widget.LowImportance
+		}
+		labels["Error"].Importance = importance
+		labels["Error"].Refresh()
+	}
+	if len(history) > 0 {
+		guiHistoryMu.Lock()
+		for _, rec := range history {
+			guiHistory = append([]activityRecord{rec}, guiHistory...)
+		}
+		if len(guiHistory) > guiHistoryLimit {
+			guiHistory = guiHistory[:guiHistoryLimit]
 		}
+		guiHistoryMu.Unlock()
+		guiHistoryList.Refresh()
 	}
 }
@@ -0,0 +1,131 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/test"
+	"fyne.io/fyne/v2/widget"
+)
+
+// setupTestGUI publishes a minimal set of widgets for the GUI updater and
+// removes them when the test ends
+func setupTestGUI(t *testing.T) map[string]*widget.Label {
+	a := test.NewTempApp(t)
+
+	labels := make(map[string]*widget.Label)
+	for _, field := range []string{"Status", "SRC", "FrameNumber", "Error"} {
+		labels[field] = widget.NewLabel("")
+	}
+	history := widget.NewList(
+		func() int {
+			guiHistoryMu.Lock()
+			defer guiHistoryMu.Unlock()
+			return len(guiHistory)
+		},
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(widget.ListItemID, fyne.CanvasObject) {},
+	)
+
+	guiMu.Lock()
+	guiLabels = labels
+	guiLevel = widget.NewProgressBar()
+	guiWindow = a.NewWindow(guiTitle)
+	guiHistoryList = history
+	guiMu.Unlock()
+
+	t.Cleanup(func() {
+		guiMu.Lock()
+		guiLabels = nil
+		guiLevel = nil
+		guiWindow = nil
+		guiHistoryList = nil
+		guiValues = make(map[string]string)
+		guiMu.Unlock()
+
+		guiHistoryMu.Lock()
+		guiHistory = nil
+		guiHistoryMu.Unlock()
+	})
+	return labels
+}
+
+// TestGUIConcurrentUpdates queues updates from many goroutines while the
+// updater applies them, checking the latest values win. Run it with -race to
+// catch widgets being touched off the updater.
+func TestGUIConcurrentUpdates(t *testing.T) {
+	labels := setupTestGUI(t)
+
+	const writers = 8
+	const updates = 500
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				updateGUI("FrameNumber", fmt.Sprint(i))
+				updateGUI("Status", fmt.Sprintf("Receiving %d", i))
+				updateGUI("Error", "")
+				updateGUILevel(float64(i) / updates)
+				setGUIAlert(i%2 == 0)
+				setGUIFlash(i%2 == 0)
+				if i%50 == 0 {
+					addGUIHistory(activityRecord{SRC: "N0CALL", DST: "@ALL", Start: time.Now(), End: time.Now()})
+				}
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Apply updates the way applyGUIUpdates does until the writers finish
+	for running := true; running; {
+		select {
+		case <-guiDirty:
+			fyne.DoAndWait(applyGUIPending)
+		case <-done:
+			running = false
+		}
+	}
+	updateGUI("SRC", "KC1AWV")
+	fyne.DoAndWait(applyGUIPending)
+
+	if got := labels["SRC"].Text; got != "KC1AWV" {
+		t.Errorf("SRC = %q, want %q", got, "KC1AWV")
+	}
+	if got, want := labels["FrameNumber"].Text, fmt.Sprint(updates-1); got != want {
+		t.Errorf("FrameNumber = %q, want %q", got, want)
+	}
+	if got := labels["Error"].Text; got != "None" {
+		t.Errorf("Error = %q, want %q", got, "None")
+	}
+	guiHistoryMu.Lock()
+	defer guiHistoryMu.Unlock()
+	if got, want := len(guiHistory), writers*updates/50; got != want {
+		t.Errorf("history has %d transmissions, want %d", got, want)
+	}
+}
@@ -0,0 +1,310 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"go-m17-listen/m17"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Mock reflector settings. PINGs are sent far more often than by a real
+// reflector to keep the test short.
+const (
+	mockReflectorTimeout = 5 * time.Second
+	mockPingInterval     = 20 * time.Millisecond
+)
+
+// mockReflector is a local UDP server standing in for a relay/reflector. It
+// answers LSTN with ACKN and DISC with DISC, counts PONGs, and hands every
+// packet the client sends to the test.
+type mockReflector struct {
+	conn     *net.UDPConn
+	received chan []byte
+	pongs    atomic.Int32
+	mu       sync.Mutex
+	client   *net.UDPAddr
+}
+
+// newMockReflector starts a mock reflector on a local port, stopping it when
+// the test ends
+func newMockReflector(t *testing.T) *mockReflector {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to start mock reflector: %v", err)
+	}
+	r := &mockReflector{conn: conn, received: make(chan []byte, 64)}
+	go r.serve()
+	t.Cleanup(func() { conn.Close() })
+	return r
+}
+
+// serve answers the client until the connection is closed
+func (r *mockReflector) serve() {
+	buf := make([]byte, udpBufferSize)
+	for {
+		n, addr, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		packet := append([]byte(nil), buf[:n]...)
+		if len(packet) < 4 {
+			continue
+		}
+		r.mu.Lock()
+		r.client = addr
+		r.mu.Unlock()
+
+		switch string(packet[:4]) {
+		case m17.MagicLSTN:
+			r.conn.WriteToUDP([]byte(m17.MagicACKN), addr)
+		case m17.MagicDISC:
+			r.conn.WriteToUDP([]byte(m17.MagicDISC), addr)
+		case m17.MagicPONG:
+			r.pongs.Add(1)
+		}
+		select {
+		case r.received <- packet:
+		default:
+		}
+	}
+}
+
+// send sends a packet to the subscribed client
+func (r *mockReflector) send(t *testing.T, packet []byte) {
+	t.Helper()
+	r.mu.Lock()
+	client := r.client
+	r.mu.Unlock()
+	if client == nil {
+		t.Fatal("no client subscribed to the mock reflector")
+	}
+	if _, err := r.conn.WriteToUDP(packet, client); err != nil {
+		t.Fatalf("mock reflector failed to send: %v", err)
+	}
+}
+
+// keepalive sends PING to the subscribed client every mockPingInterval, as a
+// reflector does, until the returned function is called
+func (r *mockReflector) keepalive() (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(mockPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			r.mu.Lock()
+			client := r.client
+			r.mu.Unlock()
+			if client != nil {
+				r.conn.WriteToUDP([]byte(m17.MagicPING), client)
+			}
+		}
+	}()
+	return sync.OnceFunc(func() {
+		close(done)
+		<-finished
+	})
+}
+
+// expect waits for the client to send a packet with the given magic,
+// skipping others such as re-sent LSTNs
+func (r *mockReflector) expect(t *testing.T, magic string) []byte {
+	t.Helper()
+	timeout := time.After(mockReflectorTimeout)
+	for {
+		select {
+		case packet := <-r.received:
+			if string(packet[:4]) == magic {
+				return packet
+			}
+		case <-timeout:
+			t.Fatalf("client didn't send %s", magic)
+		}
+	}
+}
+
+// fakePlayer records the audio written to it instead of playing it
+type fakePlayer struct {
+	mu     sync.Mutex
+	pcm    []byte
+	closed bool
+}
+
+func (p *fakePlayer) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pcm = append(p.pcm, b...)
+	return len(b), nil
+}
+
+func (p *fakePlayer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+// testVoiceFrame builds a 3200 bps voice stream frame from KC1AWV to
+// BROADCAST with a valid CRC
+func testVoiceFrame(t *testing.T, streamID, frameNumber uint16) []byte {
+	t.Helper()
+	packet := make([]byte, m17.FrameSize)
+	copy(packet, m17.MagicM17)
+	binary.BigEndian.PutUint16(packet[4:6], streamID)
+	src, err := m17.EncodeCallsign("KC1AWV")
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(packet[6:12], []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+	copy(packet[12:18], src)
+	binary.BigEndian.PutUint16(packet[18:20], 0x0005)
+	binary.BigEndian.PutUint16(packet[34:36], frameNumber)
+	for i := 36; i < 52; i++ {
+		packet[i] = byte(i * 7)
+	}
+	binary.BigEndian.PutUint16(packet[52:54], m17.CRC16(packet[:52]))
+	return packet
+}
+
+// TestClientAgainstMockReflector runs a client through a whole session with
+// a mock reflector: the LSTN/ACKN handshake, periodic PINGs each answered
+// with PONG, a voice stream decoded to the audio output and recorded in the activity log,
+// and the DISC handshake on shutdown.
+func TestClientAgainstMockReflector(t *testing.T) {
+	r := newMockReflector(t)
+	activityPath := filepath.Join(t.TempDir(), "activity.jsonl")
+	client, err := NewClient("N0CALL", r.conn.LocalAddr().String(), 'A', ClientConfig{
+		CodecMode:      codecModeAuto,
+		Volume:         1,
+		PCMOutput:      io.Discard,
+		NoChime:        true,
+		Once:           true,
+		OnceTimeout:    time.Minute,
+		ActivityLog:    activityPath,
+		ActivityFormat: ActivityFormatJSONL,
+	})
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	player := &fakePlayer{}
+	client.sinks = []*audioSink{newAudioSink("fake", player, nil, true)}
+	defer client.Close()
+
+	callsign, err := m17.EncodeCallsign("N0CALL")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Subscribe to module A
+	if err := client.connect(); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	lstn := r.expect(t, m17.MagicLSTN)
+	if want := append(append([]byte(m17.MagicLSTN), callsign...), 'A'); !bytes.Equal(lstn, want) {
+		t.Errorf("LSTN = %q, want %q", lstn, want)
+	}
+	client.startReceiver(client.listen)
+	if err := client.awaitACKN(); err != nil {
+		t.Fatalf("awaitACKN failed: %v", err)
+	}
+
+	// Answer the keepalives for the rest of the session
+	stopKeepalive := r.keepalive()
+	defer stopKeepalive()
+	for i := 0; i < 3; i++ {
+		pong := r.expect(t, m17.MagicPONG)
+		if want := append([]byte(m17.MagicPONG), callsign...); !bytes.Equal(pong, want) {
+			t.Errorf("PONG = %q, want %q", pong, want)
+		}
+	}
+
+	// Receive a three frame voice stream
+	frameNumbers := []uint16{0, 1, 2 | m17.FrameNumberEOS}
+	for _, fn := range frameNumbers {
+		r.send(t, testVoiceFrame(t, 0x1234, fn))
+	}
+	select {
+	case <-client.transmitted:
+	case <-time.After(mockReflectorTimeout):
+		t.Fatal("the stream didn't end")
+	}
+
+	// Disconnect
+	stopKeepalive()
+	if pongs := r.pongs.Load(); pongs < 3 {
+		t.Errorf("client answered %d PINGs, want at least 3", pongs)
+	}
+	if !client.disconnect() {
+		t.Error("disconnect didn't get a DISC reply")
+	}
+	disc := r.expect(t, m17.MagicDISC)
+	if want := append([]byte(m17.MagicDISC), callsign...); !bytes.Equal(disc, want) {
+		t.Errorf("DISC = %q, want %q", disc, want)
+	}
+	client.Close()
+
+	// Every frame was decoded to 40ms of audio and played
+	player.mu.Lock()
+	played, closed := len(player.pcm), player.closed
+	player.mu.Unlock()
+	if want := len(frameNumbers) * m17FrameSamples * 2; played != want {
+		t.Errorf("played %d bytes of audio, want %d", played, want)
+	}
+	if !closed {
+		t.Error("the audio output wasn't closed")
+	}
+
+	// The stream was recorded once, with all its frames
+	data, err := os.ReadFile(activityPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []activityRecord
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for decoder.More() {
+		var rec activityRecord
+		if err := decoder.Decode(&rec); err != nil {
+			t.Fatalf("bad activity log %q: %v", data, err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 1 {
+		t.Fatalf("activity log has %d records, want 1: %s", len(records), data)
+	}
+	rec := records[0]
+	if rec.SRC != "KC1AWV" || rec.DST != "BROADCAST" || rec.StreamID != 0x1234 || rec.Frames != len(frameNumbers) || rec.Module != "A" {
+		t.Errorf("activity record = %+v, want KC1AWV > BROADCAST, stream 0x1234, %d frames, module A", rec, len(frameNumbers))
+	}
+}
@@ -0,0 +1,230 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"go-m17-listen/m17"
+	"sync"
+	"time"
+)
+
+// m17FrameInterval is the duration of audio carried by one M17 stream frame
+const m17FrameInterval = 40 * time.Millisecond
+
+// Concealment strategies for lost frames
+const (
+	ConcealSilence = "silence"
+	ConcealRepeat  = "repeat"
+)
+
+// Concealment limits. The last frame is repeated at most maxConcealRepeats
+// times before falling back to silence, and gaps longer than
+// maxConcealFrames are treated as the stream resuming rather than loss.
+const (
+	maxConcealRepeats = 3
+	maxConcealFrames  = 25 // 1 second
+)
+
+// concealer fills the 40ms slots of lost frames with silence or by repeating
+// the last frame, keeping the audio clock steady
+type concealer struct {
+	strategy string
+	last     []int16
+	repeats  int
+}
+
+// conceal returns the audio to play in place of a lost frame
+func (c *concealer) conceal() []int16 {
+	if c.strategy == ConcealRepeat && c.last != nil && c.repeats < maxConcealRepeats {
+		c.repeats++
+		return c.last
+	}
+	return make([]int16, m17FrameSamples)
+}
+
+// played records a received frame as the one to repeat
+func (c *concealer) played(audio []int16) {
+	c.last = audio
+	c.repeats = 0
+}
+
+// reset forgets the last frame at the start of a new stream
+func (c *concealer) reset() {
+	c.last = nil
+	c.repeats = 0
+}
+
+// jitterFrame is a decoded audio frame waiting in the jitter buffer
+type jitterFrame struct {
+	audio []int16
+	eos   bool
+}
+
+// jitterBuffer reorders decoded audio frames by frame number and releases
+// them to the player on a steady 40ms cadence
+type jitterBuffer struct {
+	mu       sync.Mutex
+	depth    int
+	output   func([]int16)
+	streamID uint16
+	frames   map[uint16]jitterFrame
+	next     uint16
+	playing  bool
+	ended    bool
+	conceal  concealer
+}
+
+// newJitterBuffer creates a jitter buffer holding the given amount of audio
+// before playback starts, concealing lost frames with the given strategy
+func newJitterBuffer(target time.Duration, strategy string, output func([]int16)) *jitterBuffer {
+	depth := int(target / m17FrameInterval)
+	if depth < 1 {
+		depth = 1
+	}
+	return &jitterBuffer{
+		depth:   depth,
+		output:  output,
+		frames:  make(map[uint16]jitterFrame),
+		conceal: concealer{strategy: strategy},
+	}
+}
+
+// push adds a decoded frame to the buffer. Frames arriving after their slot
+// has already been played are dropped.
+func (j *jitterBuffer) push(streamID, frameNumber uint16, audio []int16) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// A new stream replaces whatever is left of the previous one
+	if streamID != j.streamID {
+		j.resetLocked()
+		j.streamID = streamID
+	}
+
+	seq := frameNumber & m17.FrameNumberMask
+	if j.ended || (j.playing && frameBefore(seq, j.next)) {
+		return
+	}
+	j.frames[seq] = jitterFrame{audio: audio, eos: frameNumber&m17.FrameNumberEOS != 0}
+
+	// Skip ahead if the buffer has grown well past its target depth
+	for j.playing && len(j.frames) > 2*j.depth {
+		delete(j.frames, j.next)
+		j.next = (j.next + 1) & m17.FrameNumberMask
+	}
+}
+
+// run releases buffered frames until the context is cancelled
+func (j *jitterBuffer) run(ctx context.Context) {
+	ticker := time.NewTicker(m17FrameInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if audio := j.pop(); audio != nil {
+				j.output(audio)
+			}
+		}
+	}
+}
+
+// pop returns the next frame to play, concealment for a missing frame, or nil
+// when nothing should be played
+func (j *jitterBuffer) pop() []int16 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if !j.playing {
+		if len(j.frames) < j.depth && !j.hasEOSLocked() {
+			return nil
+		}
+		if len(j.frames) == 0 {
+			return nil
+		}
+		j.next = j.oldestLocked()
+		j.playing = true
+	}
+
+	// Nothing left to play, the stream ended without an EOS frame
+	if len(j.frames) == 0 {
+		j.playing = false
+		return nil
+	}
+
+	frame, ok := j.frames[j.next]
+	delete(j.frames, j.next)
+	j.next = (j.next + 1) & m17.FrameNumberMask
+	if !ok {
+		return j.conceal.conceal()
+	}
+	j.conceal.played(frame.audio)
+	if frame.eos {
+		j.resetLocked()
+		j.ended = true
+	}
+	return frame.audio
+}
+
+// reset discards all buffered frames, e.g. when another source takes over the
+// stream ID
+func (j *jitterBuffer) reset() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.resetLocked()
+}
+
+// resetLocked discards all buffered frames. The caller must hold j.mu.
+func (j *jitterBuffer) resetLocked() {
+	clear(j.frames)
+	j.playing = false
+	j.ended = false
+	j.conceal.reset()
+}
+
+// hasEOSLocked reports whether the end of stream frame has been buffered
+func (j *jitterBuffer) hasEOSLocked() bool {
+	for _, frame := range j.frames {
+		if frame.eos {
+			return true
+		}
+	}
+	return false
+}
+
+// oldestLocked returns the earliest buffered frame number
+func (j *jitterBuffer) oldestLocked() uint16 {
+	first := true
+	var oldest uint16
+	for seq := range j.frames {
+		if first || frameBefore(seq, oldest) {
+			oldest = seq
+			first = false
+		}
+	}
+	return oldest
+}
+
+// frameBefore reports whether frame number a comes before b, accounting for
+// wraparound of the 15-bit frame counter
+func frameBefore(a, b uint16) bool {
+	diff := (b - a) & m17.FrameNumberMask
+	return diff != 0 && diff < m17.FrameNumberMask/2
+}
@@ -0,0 +1,124 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"go-m17-listen/m17"
+	"reflect"
+	"testing"
+)
+
+// TestJitterBufferReorders feeds frames out of order, including across the
+// 15-bit frame number wraparound, and checks they are played in order
+func TestJitterBufferReorders(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames []uint16 // Frame numbers in arrival order
+		want   []uint16 // Frame numbers in playout order
+	}{
+		{
+			name:   "in order",
+			frames: []uint16{0, 1, 2, 3, 4 | m17.FrameNumberEOS},
+			want:   []uint16{0, 1, 2, 3, 4},
+		},
+		{
+			name:   "swapped pairs",
+			frames: []uint16{1, 0, 3, 2, 5 | m17.FrameNumberEOS, 4},
+			want:   []uint16{0, 1, 2, 3, 4, 5},
+		},
+		{
+			name:   "wraparound",
+			frames: []uint16{0x7FFE, 0x0000, 0x7FFD, 0x7FFF, 0x0002 | m17.FrameNumberEOS, 0x0001},
+			want:   []uint16{0x7FFD, 0x7FFE, 0x7FFF, 0x0000, 0x0001, 0x0002},
+		},
+		{
+			name:   "lost frame concealed",
+			frames: []uint16{0, 1, 2, 3, 5, 6, 7 | m17.FrameNumberEOS},
+			want:   []uint16{0, 1, 2, 3, 0xFFFF, 5, 6, 7},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := newJitterBuffer(4*m17FrameInterval, ConcealSilence, nil)
+			for _, fn := range tt.frames {
+				j.push(0x1234, fn, frameAudio(fn&m17.FrameNumberMask))
+			}
+
+			var got []uint16
+			for i := 0; i < len(tt.want)+2; i++ {
+				audio := j.pop()
+				if audio == nil {
+					continue
+				}
+				if audio[0] == 0 && audio[1] == 0 {
+					got = append(got, 0xFFFF) // Concealed frame
+					continue
+				}
+				got = append(got, uint16(audio[1]))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("played %04X, want %04X", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestJitterBufferDropsLateFrames checks that a frame arriving after its slot
+// was played is dropped rather than played out of order
+func TestJitterBufferDropsLateFrames(t *testing.T) {
+	j := newJitterBuffer(m17FrameInterval, ConcealSilence, nil)
+	j.push(0x1234, 0, frameAudio(0))
+	j.push(0x1234, 1, frameAudio(1))
+	if audio := j.pop(); audio == nil || audio[1] != 0 {
+		t.Fatalf("first frame = %v, want frame 0", audio)
+	}
+	j.push(0x1234, 0, frameAudio(0))
+	if audio := j.pop(); audio == nil || audio[1] != 1 {
+		t.Fatalf("second frame = %v, want frame 1", audio)
+	}
+}
+
+// TestFrameBefore checks frame number ordering across the wraparound
+func TestFrameBefore(t *testing.T) {
+	tests := []struct {
+		a, b uint16
+		want bool
+	}{
+		{0, 1, true},
+		{1, 0, false},
+		{5, 5, false},
+		{0x7FFF, 0, true},
+		{0, 0x7FFF, false},
+		{0x7FF0, 0x0010, true},
+	}
+	for _, tt := range tests {
+		if got := frameBefore(tt.a, tt.b); got != tt.want {
+			t.Errorf("frameBefore(%04X, %04X) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// frameAudio returns a frame of audio marked with its frame number, the
+// first sample being non-zero so it can't be mistaken for concealment
+func frameAudio(seq uint16) []int16 {
+	audio := make([]int16, m17FrameSamples)
+	audio[0] = 1
+	audio[1] = int16(seq)
+	return audio
+}
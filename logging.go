@@ -0,0 +1,71 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// logLevel is the minimum level of log messages written
+var logLevel slog.LevelVar
+
+// parseLogLevel parses a log level name
+func parseLogLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level: %s", name)
+}
+
+// setLogOutput sends log messages at or above logLevel to w
+func setLogOutput(w io.Writer) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: &logLevel})))
+}
+
+// fatal logs an error, reports it as a JSON event and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	jsonEvents.emit(jsonEvent{Event: EventError, Error: msg})
+	os.Exit(1)
+}
+
+// formatCompactLine formats a finished transmission as one grep-friendly
+// line for the compact log, e.g.
+// "12:00:00 RX KC1AWV->ALL module=A dur=4.2s loss=0%"
+func formatCompactLine(rec activityRecord, lossPercent float64) string {
+	module := ""
+	if rec.Module != "" {
+		module = " module=" + rec.Module
+	}
+	loss := strconv.FormatFloat(math.Round(lossPercent*10)/10, 'f', -1, 64)
+	return fmt.Sprintf("%s RX %s->%s%s dur=%.1fs loss=%s%%",
+		rec.Start.Format("15:04:05"), rec.SRC, rec.DST, module, rec.End.Sub(rec.Start).Seconds(), loss)
+}
@@ -0,0 +1,47 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"go-m17-listen/m17"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// saveLSF writes the LICH of the first frame of a stream to its own file in
+// the LSF directory, completed with its CRC so the file holds a valid 30-byte
+// link setup frame. Files are named by arrival time and stream ID, e.g.
+// 20241130T120000Z-1234.lsf.
+func (c *Client) saveLSF(streamID uint16, lich []byte) {
+	if c.lsfDir == "" {
+		return
+	}
+	lsf := make([]byte, m17.LSFSize)
+	copy(lsf, lich[:m17.LICHSize])
+	binary.BigEndian.PutUint16(lsf[m17.LICHSize:], m17.CRC16(lsf[:m17.LICHSize]))
+
+	name := fmt.Sprintf("%s-%04X.lsf", time.Now().UTC().Format("20060102T150405Z"), streamID)
+	if err := os.WriteFile(filepath.Join(c.lsfDir, name), lsf, 0644); err != nil {
+		slog.Error("failed to save LSF", "err", err)
+		updateField("Error", fmt.Sprintf("failed to save LSF: %v", err))
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package m17
+
+import (
+	"fmt"
+	"strings"
+)
+
+// base40Chars is the character set used for encoding callsigns
+const (
+	base40Chars = " ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-/."
+)
+
+// Reserved M17 addresses. Addresses from AddressHashStart up to AddressHashEnd
+// encode callsigns starting with #, offset by 40^9. The addresses from
+// AddressHashEnd up to the broadcast address are reserved.
+const (
+	AddressNone      = 0x000000000000
+	AddressHashStart = 0xEE6B28000000
+	AddressHashEnd   = 0xF46109000000
+	AddressBroadcast = 0xFFFFFFFFFFFF
+)
+
+// BroadcastCallsign is the callsign encoded as the broadcast address
+const BroadcastCallsign = "@ALL"
+
+// MaxCallsignLength is the longest callsign that fits in a 48-bit address,
+// one less for callsigns starting with #
+const MaxCallsignLength = 9
+
+// EncodeCallsign encodes a callsign into a 6-byte address. Lowercase letters
+// are encoded as uppercase, and BroadcastCallsign as the broadcast address.
+func EncodeCallsign(callsign string) ([]byte, error) {
+	address := uint64(0)
+
+	if callsign == "" {
+		return nil, fmt.Errorf("empty callsign")
+	}
+	if strings.EqualFold(callsign, BroadcastCallsign) {
+		return []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, nil
+	}
+	hash := strings.HasPrefix(callsign, "#")
+	if hash {
+		callsign = callsign[1:]
+		if len(callsign) > MaxCallsignLength-1 {
+			return nil, fmt.Errorf("callsign too long: #%s (at most %d characters after #)", callsign, MaxCallsignLength-1)
+		}
+	} else if len(callsign) > MaxCallsignLength {
+		return nil, fmt.Errorf("callsign too long: %s (at most %d characters)", callsign, MaxCallsignLength)
+	}
+
+	for i := len(callsign) - 1; i >= 0; i-- {
+		c := callsign[i]
+		val := 0
+		switch {
+		case c == ' ':
+			val = 0
+		case 'A' <= c && c <= 'Z':
+			val = int(c-'A') + 1
+		case 'a' <= c && c <= 'z':
+			val = int(c-'a') + 1
+		case '0' <= c && c <= '9':
+			val = int(c-'0') + 27
+		case c == '-':
+			val = 37
+		case c == '/':
+			val = 38
+		case c == '.':
+			val = 39
+		default:
+			return nil, fmt.Errorf("invalid character in callsign: %c", c)
+		}
+
+		address = address*40 + uint64(val)
+	}
+	if hash {
+		address += AddressHashStart
+	}
+
+	result := make([]byte, 6)
+	for i := 5; i >= 0; i-- {
+		result[i] = byte(address & 0xFF)
+		address >>= 8
+	}
+
+	return result, nil
+}
+
+// DecodeCallsign decodes a 6-byte address into a callsign. The first
+// character is the least significant base 40 digit, matching EncodeCallsign.
+// Trailing spaces encode as high zero digits, so they are never decoded,
+// while spaces within the callsign are kept. Reserved addresses that don't
+// encode a callsign are shown as (reserved).
+func DecodeCallsign(encoded []byte) string {
+	address := uint64(0)
+
+	for _, b := range encoded {
+		address = address*256 + uint64(b)
+	}
+
+	switch {
+	case address == AddressNone:
+		return "(none)"
+	case address == AddressBroadcast:
+		return "BROADCAST"
+	case address >= AddressHashEnd:
+		return "(reserved)"
+	}
+
+	callsign := ""
+	if address >= AddressHashStart {
+		callsign = "#"
+		address -= AddressHashStart
+	}
+	for address > 0 {
+		idx := address % 40
+		callsign += string(base40Chars[idx])
+		address /= 40
+	}
+
+	return callsign
+}
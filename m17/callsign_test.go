@@ -0,0 +1,184 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package m17
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestCallsignRoundTrip checks that decoding an encoded callsign gives the
+// same callsign back, in the same character order
+func TestCallsignRoundTrip(t *testing.T) {
+	for _, callsign := range []string{"KC1AWV", "KC1AWA", "N0CALL", "W1AW", "A", "M17-USA C"} {
+		encoded, err := EncodeCallsign(callsign)
+		if err != nil {
+			t.Fatalf("EncodeCallsign(%q) failed: %v", callsign, err)
+		}
+		if got := DecodeCallsign(encoded); got != callsign {
+			t.Errorf("DecodeCallsign(EncodeCallsign(%q)) = %q", callsign, got)
+		}
+	}
+}
+
+// TestDecodeReservedAddresses checks the none and broadcast addresses
+func TestDecodeReservedAddresses(t *testing.T) {
+	tests := []struct {
+		encoded []byte
+		want    string
+	}{
+		{[]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, "(none)"},
+		{[]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, "BROADCAST"},
+	}
+	for _, tt := range tests {
+		if got := DecodeCallsign(tt.encoded); got != tt.want {
+			t.Errorf("DecodeCallsign(% X) = %q, want %q", tt.encoded, got, tt.want)
+		}
+	}
+}
+
+// TestEncodeCallsignKnownAddress checks an address against a hand computed
+// base 40 value, the first character being the least significant digit
+func TestEncodeCallsignKnownAddress(t *testing.T) {
+	// "AB" is A (1) + B (2) * 40 = 81
+	want := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x51}
+	got, err := EncodeCallsign("AB")
+	if err != nil {
+		t.Fatalf("EncodeCallsign failed: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("EncodeCallsign(\"AB\") = % X, want % X", got, want)
+	}
+}
+
+// TestEncodeCallsign checks what EncodeCallsign accepts by decoding the
+// result, which is uppercase and shows the broadcast address as BROADCAST
+func TestEncodeCallsign(t *testing.T) {
+	tests := []struct {
+		name     string
+		callsign string
+		want     string
+		wantErr  bool
+	}{
+		{"maximum length", "KC1AWV-10", "KC1AWV-10", false},
+		{"maximum length after #", "#M17-USA1", "#M17-USA1", false},
+		{"lowercase", "kc1awv", "KC1AWV", false},
+		{"mixed case", "Kc1Awv/p", "KC1AWV/P", false},
+		{"broadcast", "@ALL", "BROADCAST", false},
+		{"broadcast lowercase", "@all", "BROADCAST", false},
+		{"empty", "", "", true},
+		{"underscore", "KC1_AWV", "", true},
+		{"punctuation", "KC1AWV!", "", true},
+		{"non-ASCII letter", "KÇ1AWV", "", true},
+		{"@ other than ALL", "@KC1AWV", "", true},
+		{"# not first", "KC1#AWV", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := EncodeCallsign(tt.callsign)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("EncodeCallsign(%q) = % X, want an error", tt.callsign, encoded)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EncodeCallsign(%q) failed: %v", tt.callsign, err)
+			}
+			if got := DecodeCallsign(encoded); got != tt.want {
+				t.Errorf("DecodeCallsign(EncodeCallsign(%q)) = %q, want %q", tt.callsign, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCallsignBoundaries checks the edges of the base 40 and # address ranges
+// and the length limits
+func TestCallsignBoundaries(t *testing.T) {
+	tests := []struct {
+		name     string
+		callsign string
+		address  []byte
+	}{
+		{"largest base 40 value", ".........", []byte{0xEE, 0x6B, 0x27, 0xFF, 0xFF, 0xFF}},
+		{"smallest # value", "#", []byte{0xEE, 0x6B, 0x28, 0x00, 0x00, 0x00}},
+		{"largest # value", "#........", []byte{0xF4, 0x61, 0x08, 0xFF, 0xFF, 0xFF}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := EncodeCallsign(tt.callsign)
+			if err != nil {
+				t.Fatalf("EncodeCallsign(%q) failed: %v", tt.callsign, err)
+			}
+			if !bytes.Equal(encoded, tt.address) {
+				t.Errorf("EncodeCallsign(%q) = % X, want % X", tt.callsign, encoded, tt.address)
+			}
+			if got := DecodeCallsign(encoded); got != tt.callsign {
+				t.Errorf("DecodeCallsign(% X) = %q, want %q", encoded, got, tt.callsign)
+			}
+		})
+	}
+
+	for _, callsign := range []string{"KC1AWV-100", "#M17-USA12", "##"} {
+		if encoded, err := EncodeCallsign(callsign); err == nil {
+			t.Errorf("EncodeCallsign(%q) = % X, want an error", callsign, encoded)
+		}
+	}
+}
+
+// TestDecodeOutOfRangeAddress checks the reserved addresses between the #
+// range and the broadcast address don't decode to a callsign
+func TestDecodeOutOfRangeAddress(t *testing.T) {
+	for _, encoded := range [][]byte{
+		{0xF4, 0x61, 0x09, 0x00, 0x00, 0x00},
+		{0xF8, 0x00, 0x00, 0x00, 0x00, 0x00},
+		{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFE},
+	} {
+		if got := DecodeCallsign(encoded); got != "(reserved)" {
+			t.Errorf("DecodeCallsign(% X) = %q, want %q", encoded, got, "(reserved)")
+		}
+	}
+}
+
+// TestDecodeCallsignSpaces checks trailing spaces, which encode as high zero
+// digits, are never decoded while leading spaces and spaces within the
+// callsign are kept
+func TestDecodeCallsignSpaces(t *testing.T) {
+	tests := []struct {
+		callsign string
+		want     string
+	}{
+		{"KC1AWV", "KC1AWV"},
+		{"KC1AWV   ", "KC1AWV"},
+		{" KC1AWV", " KC1AWV"},
+		{"M17-USA C", "M17-USA C"},
+		{"M17 C    ", "M17 C"},
+		{"#M17 C ", "#M17 C"},
+		{"#        ", "#"},
+	}
+	for _, tt := range tests {
+		encoded, err := EncodeCallsign(tt.callsign)
+		if err != nil {
+			t.Fatalf("EncodeCallsign(%q) failed: %v", tt.callsign, err)
+		}
+		if got := DecodeCallsign(encoded); got != tt.want {
+			t.Errorf("DecodeCallsign(EncodeCallsign(%q)) = %q, want %q", tt.callsign, got, tt.want)
+		}
+	}
+}
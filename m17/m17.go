@@ -0,0 +1,184 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package m17 parses M17 frames and packets as sent by M17 relays and
+// reflectors over IP, and encodes and decodes M17 callsigns.
+package m17
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Packet MAGIC constants
+const (
+	MagicLSTN = "LSTN"
+	MagicACKN = "ACKN"
+	MagicNACK = "NACK"
+	MagicPING = "PING"
+	MagicPONG = "PONG"
+	MagicDISC = "DISC"
+	MagicM17  = "M17 "
+	MagicM17P = "M17P"
+)
+
+// Frame and LSF lengths in bytes
+const (
+	FrameSize = 54 // Stream frame including MAGIC and CRC
+	LSFSize   = 30 // Link setup frame including CRC
+	LICHSize  = 28 // Link setup frame without CRC, as carried by stream frames
+)
+
+// M17 frame number constants
+const (
+	FrameNumberEOS  = 0x8000 // End of stream marker
+	FrameNumberMask = 0x7FFF // Frame counter bits
+)
+
+// Data types
+const (
+	DataTypeData      = 0b01
+	DataTypeVoice     = 0b10
+	DataTypeVoiceData = 0b11
+)
+
+// Encryption types
+const (
+	EncryptionNone      = 0b00
+	EncryptionScrambler = 0b01
+	EncryptionAES       = 0b10
+)
+
+// META field contents, selected by the encryption subtype when the stream is
+// not encrypted
+const (
+	MetaText             = 0b00
+	MetaGNSS             = 0b01
+	MetaExtendedCallsign = 0b10
+)
+
+// ErrBadCRC is returned when a frame or LSF fails its CRC check
+var ErrBadCRC = errors.New("bad CRC")
+
+// Type is the TYPE field of an LSF
+type Type uint16
+
+// PacketStreamIndicator returns 1 for stream mode and 0 for packet mode
+func (t Type) PacketStreamIndicator() uint16 {
+	return uint16(t) & 0x0001
+}
+
+// DataTypeIndicator returns the data type, one of the DataType constants
+func (t Type) DataTypeIndicator() uint16 {
+	return (uint16(t) >> 1) & 0x0003
+}
+
+// EncryptionType returns the encryption type, one of the Encryption constants
+func (t Type) EncryptionType() uint16 {
+	return (uint16(t) >> 3) & 0x0003
+}
+
+// EncryptionSubtype returns the encryption subtype, which selects the META
+// contents when the stream is not encrypted
+func (t Type) EncryptionSubtype() uint16 {
+	return (uint16(t) >> 5) & 0x0003
+}
+
+// ChannelAccessNumber returns the channel access number
+func (t Type) ChannelAccessNumber() uint16 {
+	return (uint16(t) >> 7) & 0x000F
+}
+
+// LSF is a link setup frame, which stream frames carry as their LICH
+type LSF struct {
+	DST  string // Destination callsign
+	SRC  string // Source callsign
+	Type Type
+	Meta []byte // META field, 14 bytes
+}
+
+// Frame is an M17 stream frame
+type Frame struct {
+	StreamID    uint16
+	LSF         LSF
+	FrameNumber uint16
+	Payload     []byte // 16 bytes of Codec 2 or packet data
+}
+
+// EOS reports whether this is the last frame of the stream
+func (f Frame) EOS() bool {
+	return f.FrameNumber&FrameNumberEOS != 0
+}
+
+// ParseM17Frame parses an M17 stream frame, verifying its MAGIC and CRC. The
+// frame doesn't reference the packet after parsing.
+func ParseM17Frame(packet []byte) (Frame, error) {
+	if len(packet) < FrameSize {
+		return Frame{}, fmt.Errorf("invalid M17 frame length: %d", len(packet))
+	}
+	if string(packet[:4]) != MagicM17 {
+		return Frame{}, fmt.Errorf("invalid M17 frame magic: %q", packet[:4])
+	}
+	if CRC16(packet[:52]) != binary.BigEndian.Uint16(packet[52:54]) {
+		return Frame{}, ErrBadCRC
+	}
+
+	return Frame{
+		StreamID:    binary.BigEndian.Uint16(packet[4:6]),
+		LSF:         parseLICH(packet[6:34]),
+		FrameNumber: binary.BigEndian.Uint16(packet[34:36]),
+		Payload:     append([]byte(nil), packet[36:52]...),
+	}, nil
+}
+
+// ParseLSF parses a link setup frame, verifying its CRC
+func ParseLSF(lsf []byte) (LSF, error) {
+	if len(lsf) < LSFSize {
+		return LSF{}, fmt.Errorf("invalid LSF length: %d", len(lsf))
+	}
+	if CRC16(lsf[:28]) != binary.BigEndian.Uint16(lsf[28:30]) {
+		return LSF{}, ErrBadCRC
+	}
+	return parseLICH(lsf[:28]), nil
+}
+
+// parseLICH parses the fields of an LSF without its CRC
+func parseLICH(lich []byte) LSF {
+	return LSF{
+		DST:  DecodeCallsign(lich[0:6]),
+		SRC:  DecodeCallsign(lich[6:12]),
+		Type: Type(binary.BigEndian.Uint16(lich[12:14])),
+		Meta: append([]byte(nil), lich[14:28]...),
+	}
+}
+
+// CRC16 computes the M17 CRC-16 (polynomial 0x5935, initial value 0xFFFF)
+func CRC16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x5935
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
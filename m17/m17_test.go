@@ -0,0 +1,191 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package m17
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// testFrame builds a stream frame from KC1AWV to BROADCAST with a valid CRC
+func testFrame(t *testing.T, streamID, frameNumber uint16) []byte {
+	t.Helper()
+	packet := make([]byte, FrameSize)
+	copy(packet, MagicM17)
+	binary.BigEndian.PutUint16(packet[4:6], streamID)
+	copy(packet[6:12], []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+	src, err := EncodeCallsign("KC1AWV")
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(packet[12:18], src)
+	binary.BigEndian.PutUint16(packet[18:20], 0x0005)
+	for i := 20; i < 34; i++ {
+		packet[i] = byte(i)
+	}
+	binary.BigEndian.PutUint16(packet[34:36], frameNumber)
+	for i := 36; i < 52; i++ {
+		packet[i] = byte(0xA0 + i)
+	}
+	binary.BigEndian.PutUint16(packet[52:54], CRC16(packet[:52]))
+	return packet
+}
+
+// TestCRC16 checks CRC16 against the known-answer vectors of the M17
+// specification
+func TestCRC16(t *testing.T) {
+	tests := []struct {
+		data string
+		want uint16
+	}{
+		{"", 0xFFFF},
+		{"A", 0x206E},
+		{"123456789", 0x772B},
+	}
+	for _, tt := range tests {
+		if got := CRC16([]byte(tt.data)); got != tt.want {
+			t.Errorf("CRC16(%q) = %#04x, want %#04x", tt.data, got, tt.want)
+		}
+	}
+}
+
+// capturedFrame is the first frame of testdata/stream.cap, frame 0 of stream
+// 0x1234 from KC1AWV to BROADCAST, ending in its CRC 0x926B
+var capturedFrame = []byte{
+	0x4D, 0x31, 0x37, 0x20, 0x12, 0x34, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+	0x00, 0x00, 0x89, 0xCB, 0x19, 0x83, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x92, 0x6B,
+}
+
+// TestParseCapturedFrame checks a captured frame passes its CRC check and
+// fails it once a bit is flipped
+func TestParseCapturedFrame(t *testing.T) {
+	if got := CRC16(capturedFrame[:52]); got != 0x926B {
+		t.Errorf("CRC16 = %#04x, want 0x926B", got)
+	}
+	frame, err := ParseM17Frame(capturedFrame)
+	if err != nil {
+		t.Fatalf("ParseM17Frame failed: %v", err)
+	}
+	if frame.StreamID != 0x1234 || frame.LSF.SRC != "KC1AWV" || frame.LSF.DST != "BROADCAST" {
+		t.Errorf("frame = %04X from %s to %s, want 1234 from KC1AWV to BROADCAST", frame.StreamID, frame.LSF.SRC, frame.LSF.DST)
+	}
+
+	corrupt := bytes.Clone(capturedFrame)
+	corrupt[40] ^= 0x01
+	if _, err := ParseM17Frame(corrupt); !errors.Is(err, ErrBadCRC) {
+		t.Errorf("ParseM17Frame of a corrupted frame = %v, want %v", err, ErrBadCRC)
+	}
+}
+
+// TestParseM17Frame checks the fields of a valid frame, including the frame
+// number and end of stream flag
+func TestParseM17Frame(t *testing.T) {
+	tests := []struct {
+		name        string
+		frameNumber uint16
+		eos         bool
+	}{
+		{"first frame", 0x0000, false},
+		{"middle frame", 0x0123, false},
+		{"last counter value", 0x7FFF, false},
+		{"end of stream", 0x0124 | FrameNumberEOS, true},
+		{"end of stream at wraparound", 0x7FFF | FrameNumberEOS, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packet := testFrame(t, 0xBEEF, tt.frameNumber)
+			frame, err := ParseM17Frame(packet)
+			if err != nil {
+				t.Fatalf("ParseM17Frame failed: %v", err)
+			}
+			if frame.StreamID != 0xBEEF {
+				t.Errorf("StreamID = %#04x, want 0xBEEF", frame.StreamID)
+			}
+			if frame.FrameNumber != tt.frameNumber {
+				t.Errorf("FrameNumber = %#04x, want %#04x", frame.FrameNumber, tt.frameNumber)
+			}
+			if frame.EOS() != tt.eos {
+				t.Errorf("EOS() = %v, want %v", frame.EOS(), tt.eos)
+			}
+			if frame.LSF.DST != "BROADCAST" || frame.LSF.SRC != "KC1AWV" || frame.LSF.Type != 0x0005 {
+				t.Errorf("LSF = %s > %s type %#04x, want KC1AWV > BROADCAST type 0x0005", frame.LSF.SRC, frame.LSF.DST, uint16(frame.LSF.Type))
+			}
+			if !bytes.Equal(frame.LSF.Meta, packet[20:34]) {
+				t.Errorf("Meta = %x, want %x", frame.LSF.Meta, packet[20:34])
+			}
+			if !bytes.Equal(frame.Payload, packet[36:52]) {
+				t.Errorf("Payload = %x, want %x", frame.Payload, packet[36:52])
+			}
+
+			// The frame must not share memory with the packet
+			packet[20], packet[36] = ^packet[20], ^packet[36]
+			if frame.LSF.Meta[0] == packet[20] || frame.Payload[0] == packet[36] {
+				t.Error("frame references the packet after parsing")
+			}
+		})
+	}
+}
+
+// TestParseM17FrameRejects checks truncated frames, other packets and
+// corrupted frames are rejected
+func TestParseM17FrameRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		packet  func(t *testing.T) []byte
+		wantCRC bool
+	}{
+		{"empty", func(t *testing.T) []byte { return nil }, false},
+		{"short frame", func(t *testing.T) []byte { return testFrame(t, 1, 0)[:FrameSize-1] }, false},
+		{"bad magic", func(t *testing.T) []byte {
+			packet := testFrame(t, 1, 0)
+			copy(packet, MagicM17P)
+			return packet
+		}, false},
+		{"bad CRC", func(t *testing.T) []byte {
+			packet := testFrame(t, 1, 0)
+			packet[53] ^= 0x01
+			return packet
+		}, true},
+		{"corrupted payload", func(t *testing.T) []byte {
+			packet := testFrame(t, 1, 0)
+			packet[40] ^= 0x80
+			return packet
+		}, true},
+		{"corrupted frame number", func(t *testing.T) []byte {
+			packet := testFrame(t, 1, 0)
+			packet[34] ^= 0x80
+			return packet
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseM17Frame(tt.packet(t))
+			if err == nil {
+				t.Fatal("ParseM17Frame succeeded, want an error")
+			}
+			if got := errors.Is(err, ErrBadCRC); got != tt.wantCRC {
+				t.Errorf("ParseM17Frame error = %v, ErrBadCRC %v, want %v", err, got, tt.wantCRC)
+			}
+		})
+	}
+}
@@ -18,11 +18,20 @@ this program. If not, see <http://www.gnu.org/licenses/>.
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"go-m17-listen/codec2"
+	"go-m17-listen/m17"
 	"io"
 	"log"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -34,123 +43,701 @@ func main() {
 	// Parse command line arguments
 	var useTUI bool
 	var useGUI bool
+	var headless bool
+	var logFile string
+	var stdoutPCM bool
+	var fifoPath string
+	var speakers bool
+	var noAudio bool
+	var noChime bool
+	var maxTalk time.Duration
+	var pingInterval time.Duration
+	var reconnect bool
+	var filter bool
+	var filterCutoff float64
+	var noiseGate float64
+	var squelchDB float64
+	var metricsAddr string
+	var httpStreamAddr string
+	var webhookURL string
+	var watch string
+	var filterCANSpec string
+	var onlySRCSpec string
+	var capturePath string
+	var controlSocket string
+	var lsfDir string
+	var replayPath string
+	var replayFast bool
+	var key string
+	var logLevelName string
+	var compact bool
+	var jsonEventsOut bool
+	var once bool
+	var debugFrames bool
+	var localAddr string
+	var directoryURL string
+	var onceTimeout time.Duration
+	var duration time.Duration
+	var connectAttempts int
+	var scrambleKey string
+	var themeVariant string
+	var codecBitrate int
+	var jitterMs int
+	var conceal string
+	var activityLog string
+	var smsLogPath string
+	var activityFormat string
+	var timeout time.Duration
+	var configPath string
+	var callsign string
+	var network string
+	var volume float64
+	var device string
+	var listDevices bool
+	var showVersion bool
+	var selfTest bool
+	var audioBuffer int
+	var outputRate int
+	var audioBackend string
 	flag.BoolVar(&useTUI, "tui", false, "Enable TUI")
 	flag.BoolVar(&useGUI, "gui", false, "Enable GUI")
+	flag.BoolVar(&headless, "headless", false, "Run without any UI, only decoding, playing and logging")
+	flag.BoolVar(&stdoutPCM, "stdout-pcm", false, "Write decoded audio to stdout as raw 8kHz 16-bit little-endian PCM instead of playing it")
+	flag.StringVar(&fifoPath, "fifo", "", "Write decoded audio as raw 8kHz 16-bit little-endian PCM to this named pipe, created if needed, instead of playing it")
+	flag.BoolVar(&reconnect, "reconnect", false, "Re-subscribe when the relay/reflector disconnects the client instead of exiting")
+	flag.DurationVar(&pingInterval, "ping-interval", 0, "Send a PING to the relay/reflector this often, e.g. 10s, to measure the round-trip time, or 0 to disable")
+	flag.DurationVar(&maxTalk, "max-talk", 0, "Alert when a single transmission runs longer than this, e.g. 3m, or 0 to disable")
+	flag.BoolVar(&noAudio, "no-audio", false, "Don't play audio, only decode and display it, for machines without sound hardware")
+	flag.BoolVar(&noChime, "no-chime", false, "Don't play a chime and flash the GUI title when the relay/reflector accepts the connection")
+	flag.BoolVar(&speakers, "speakers", false, "Also play decoded audio on the speakers with --stdout-pcm or --fifo")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics on this address, e.g. :9117")
+	flag.StringVar(&httpStreamAddr, "http-stream-addr", "", "Serve the live audio as a WAV stream over HTTP on this address, e.g. :8017")
+	flag.StringVar(&onlySRCSpec, "only-src", "", "Only play streams from these comma-separated source callsigns, or the path of a file listing them. * matches any characters")
+	flag.StringVar(&filterCANSpec, "filter-can", "", "Only handle streams with one of these comma-separated channel access numbers (0-15)")
+	flag.StringVar(&webhookURL, "webhook", "", "POST a JSON event to this URL when a stream starts or ends")
+	flag.StringVar(&watch, "watch", "", "Alert when one of these comma-separated source callsigns is heard, or the path of a file listing them. * matches any characters, e.g. KC1*")
+	flag.BoolVar(&once, "once", false, "Exit after the first complete transmission, or with an error if none arrives within --once-timeout")
+	flag.DurationVar(&onceTimeout, "once-timeout", 10*time.Minute, "How long --once waits for a transmission")
+	flag.DurationVar(&duration, "duration", 0, "Disconnect and exit after running for this long, e.g. 30m, or 0 to run until stopped")
+	flag.BoolVar(&debugFrames, "debug-frames", false, "Dump every field of each M17 frame to the log and a frame details pane")
+	flag.StringVar(&capturePath, "capture", "", "Record received packets to this file for --replay")
+	flag.StringVar(&controlSocket, "control-socket", "", "Serve status and accept commands as JSON on a Unix socket at this path")
+	flag.StringVar(&lsfDir, "save-lsf", "", "Save the link setup frame of each stream to its own file in this directory")
+	flag.StringVar(&replayPath, "replay", "", "Replay M17 frames from a --capture or pcap file instead of connecting to a relay/reflector")
+	flag.BoolVar(&replayFast, "replay-fast", false, "Replay as fast as possible instead of in real time")
+	flag.StringVar(&key, "key", "", "Hex encoded AES-128, AES-192 or AES-256 key to decrypt encrypted streams with")
+	flag.StringVar(&scrambleKey, "scramble-key", "", "Hex encoded scrambler seed (up to 24 bits) to descramble scrambled streams with")
+	flag.StringVar(&logFile, "log-file", "", "Write log messages to this file instead of stderr")
+	flag.BoolVar(&jsonEventsOut, "json-events", false, "Write connection, stream and error events to stdout as newline-delimited JSON, running without a UI")
+	flag.BoolVar(&compact, "compact", false, "Log one line per transmission and only warnings and errors otherwise (default when running without a UI)")
+	flag.StringVar(&logLevelName, "log-level", "info", "Minimum level of log messages (debug, info, warn, error)")
+	flag.StringVar(&themeVariant, "theme", ThemeSystem, "GUI theme (system, light, dark)")
+	flag.BoolVar(&tuiNoColor, "no-color", false, "Disable colors in the TUI")
+	flag.IntVar(&codecBitrate, "codec-mode", 0, "Codec 2 mode in bps (3200, 2400, 1600), or 0 to detect per stream")
+	flag.IntVar(&jitterMs, "jitter-ms", 120, "Jitter buffer depth in milliseconds, or 0 to disable")
+	flag.BoolVar(&filter, "filter", false, "High-pass filter decoded audio to remove low-frequency rumble")
+	flag.Float64Var(&filterCutoff, "filter-cutoff", defaultFilterCutoff, "High-pass filter cutoff in Hz with --filter")
+	flag.Float64Var(&noiseGate, "noise-gate", 0, "Mute audio quieter than this level in dBFS with --filter, e.g. -45, or 0 to disable")
+	flag.Float64Var(&squelchDB, "squelch", 0, "Only play audio once it reaches this level in dBFS, e.g. -40, or 0 to disable")
+	flag.StringVar(&conceal, "conceal", ConcealSilence, "How to fill in lost frames (silence, repeat)")
+	flag.StringVar(&activityLog, "activity-log", "", "Append a record of each transmission to this file")
+	flag.StringVar(&smsLogPath, "sms-log", "", "Append each received text message with its time, source and destination to this file")
+	flag.StringVar(&activityFormat, "activity-format", ActivityFormatCSV, "Activity log format (csv, jsonl)")
+	flag.IntVar(&connectAttempts, "connect-attempts", 5, "Attempts to connect to the relay/reflector at startup, with exponential backoff between them, or 0 to retry forever")
+	flag.DurationVar(&timeout, "timeout", 30*time.Second, "Reconnect when nothing is received from the relay for this long, or 0 to disable")
+	flag.StringVar(&device, "device", "", "Audio output device (index, ID or name from --list-devices)")
+	flag.BoolVar(&listDevices, "list-devices", false, "List audio output devices and exit")
+	flag.StringVar(&audioBackend, "audio-backend", AudioBackendOto, "Audio backend (oto, pulse, alsa)")
+	flag.IntVar(&audioBuffer, "audio-buffer", defaultAudioBuffer, "Audio output buffer size in bytes, a power of two from 512 to 32768. "+
+		"Smaller buffers lower latency but may underrun on slow machines, larger buffers avoid underruns at the cost of latency (4096 is about 250ms)")
+	flag.IntVar(&outputRate, "output-rate", codecSampleRate, "Audio output sample rate in Hz, e.g. 48000 for devices that play 8kHz poorly. Audio is upsampled from 8kHz")
+	flag.Float64Var(&volume, "volume", 1.0, "Playback volume (0.0 to 2.0)")
+	flag.StringVar(&localAddr, "local-addr", "", "Local address and port to send from, e.g. 192.0.2.10:17010 or :17010 (default an ephemeral port)")
+	flag.StringVar(&directoryURL, "directory-url", defaultDirectoryURL, "URL of the JSON reflector directory used to look up reflector names")
+	flag.StringVar(&network, "net", "udp", "Network to connect over (udp, udp4, udp6)")
+	flag.StringVar(&callsign, "callsign", "", "Listener callsign (default random LSTNxxxxx)")
+	flag.StringVar(&configPath, "config", "", "Path of the TOML config file (default $XDG_CONFIG_HOME/go-m17-listen/config.toml)")
+	flag.BoolVar(&selfTest, "selftest", false, "Check Codec 2 decoding and audio output without connecting, then exit")
+	flag.BoolVar(&showVersion, "version", false, "Print the version and build information and exit")
 	flag.Parse()
 
-	if len(flag.Args()) < 1 || len(flag.Args()) > 2 {
-		log.Fatalf("Usage: %s [--tui] [--gui] <address> [module_letter]", os.Args[0])
+	if showVersion {
+		fmt.Print(versionInfo())
+		return
+	}
+
+	// Load the config file, command line flags take precedence over it
+	explicitConfig := configPath != ""
+	if !explicitConfig {
+		configPath = defaultConfigPath()
+	}
+	fileCfg, err := loadConfig(configPath, explicitConfig)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := fileCfg.applyFlags(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if !useTUI && !useGUI && !headless {
+		useTUI = fileCfg.UI == "tui"
+		useGUI = fileCfg.UI == "gui"
+		headless = fileCfg.UI == "none"
+	}
+	if headless && (useTUI || useGUI) {
+		log.Fatalf("--headless can't be combined with --tui or --gui")
+	}
+
+	// JSON events own stdout, so there is no UI
+	if jsonEventsOut {
+		if isFlagSet("tui") || isFlagSet("gui") {
+			log.Fatalf("--json-events can't be combined with --tui or --gui")
+		}
+		useTUI, useGUI, headless = false, false, true
+	}
+
+	// Run headless when the UI can't be shown, e.g. in CI or a container
+	if useTUI && !isTerminal(os.Stdout) {
+		log.Printf("--tui needs a terminal but stdout isn't one, running headless")
+		useTUI = false
+	}
+	if useGUI && !hasDisplay() {
+		log.Printf("--gui needs a display but neither DISPLAY nor WAYLAND_DISPLAY is set, running headless")
+		useGUI = false
+	}
+
+	// Write log messages to a file if requested, otherwise they go to stderr,
+	// keeping stdout clean for --stdout-pcm, unless a UI is enabled
+	level, err := parseLogLevel(logLevelName)
+	if err != nil {
+		log.Fatalf("invalid --log-level: %v (supported: debug, info, warn, error)", err)
+	}
+
+	// Keep long-running headless logs short unless asked otherwise
+	if !isFlagSet("compact") {
+		compact = !useTUI && !useGUI
+	}
+	if compact && !isFlagSet("log-level") {
+		level = slog.LevelWarn
+	}
+	logLevel.Set(level)
+	consoleOutput := io.Writer(os.Stderr)
+	logOutput := io.Discard
+	if logFile != "" {
+		f, err := os.OpenFile(logFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("failed to open log file: %v", err)
+		}
+		defer f.Close()
+		log.SetOutput(f)
+		consoleOutput = f
+		logOutput = f
+	}
+
+	if listDevices {
+		devices, err := listAudioDevices()
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		for _, device := range devices {
+			fmt.Printf("%s\t%s\t%s\n", device.Index, device.ID, device.Name)
+		}
+		return
+	}
+
+	if len(flag.Args()) > 2 || (len(flag.Args()) < 1 && fileCfg.Address == "" && replayPath == "" && !selfTest) {
+		log.Fatalf("Usage: %s [options] <address|reflector> [module_letter]", os.Args[0])
+	}
+
+	codecMode := codecModeAuto
+	if codecBitrate != 0 {
+		mode, err := codec2.ModeFromBitrate(codecBitrate)
+		if err != nil {
+			log.Fatalf("invalid --codec-mode: %v (supported: 3200, 2400, 1600)", err)
+		}
+		codecMode = mode
+	}
+
+	if jitterMs < 0 {
+		log.Fatalf("invalid --jitter-ms: %d", jitterMs)
+	}
+
+	if filterCutoff <= 0 || filterCutoff > maxFilterCutoff {
+		log.Fatalf("invalid --filter-cutoff: %g (must be above 0 and up to %g)", filterCutoff, maxFilterCutoff)
+	}
+
+	if noiseGate > 0 {
+		log.Fatalf("invalid --noise-gate: %g (must be a negative dBFS level, or 0 to disable)", noiseGate)
+	}
+
+	if squelchDB > 0 {
+		log.Fatalf("invalid --squelch: %g (must be a negative dBFS level, or 0 to disable)", squelchDB)
+	}
+
+	if conceal != ConcealSilence && conceal != ConcealRepeat {
+		log.Fatalf("invalid --conceal: %s (supported: silence, repeat)", conceal)
+	}
+
+	if themeVariant != ThemeSystem && themeVariant != ThemeLight && themeVariant != ThemeDark {
+		log.Fatalf("invalid --theme: %s (supported: system, light, dark)", themeVariant)
+	}
+
+	if audioBuffer < minAudioBuffer || audioBuffer > maxAudioBuffer || audioBuffer&(audioBuffer-1) != 0 {
+		log.Fatalf("invalid --audio-buffer: %d (must be a power of two from %d to %d)", audioBuffer, minAudioBuffer, maxAudioBuffer)
+	}
+
+	if outputRate < codecSampleRate || outputRate > maxOutputRate {
+		log.Fatalf("invalid --output-rate: %d (must be from %d to %d)", outputRate, codecSampleRate, maxOutputRate)
+	}
+
+	if audioBackend != AudioBackendOto && audioBackend != AudioBackendPulse && audioBackend != AudioBackendALSA {
+		log.Fatalf("invalid --audio-backend: %s (supported: oto, pulse, alsa)", audioBackend)
+	}
+
+	if volume < minVolume || volume > maxVolume {
+		log.Fatalf("invalid --volume: %g (must be between %g and %g)", volume, minVolume, maxVolume)
+	}
+
+	if network != "udp" && network != "udp4" && network != "udp6" {
+		log.Fatalf("invalid --net: %s (supported: udp, udp4, udp6)", network)
+	}
+
+	var laddr *net.UDPAddr
+	if localAddr != "" {
+		laddr, err = net.ResolveUDPAddr(network, localAddr)
+		if err != nil {
+			log.Fatalf("invalid --local-addr: %v", err)
+		}
+	}
+
+	if webhookURL != "" {
+		if u, err := url.Parse(webhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			log.Fatalf("invalid --webhook: %s (must be an http or https URL)", webhookURL)
+		}
+	}
+
+	var onlySRC watchList
+	if onlySRCSpec != "" {
+		onlySRC, err = parseWatchList(onlySRCSpec)
+		if err != nil {
+			log.Fatalf("invalid --only-src: %v", err)
+		}
+	}
+
+	var watchList watchList
+	if watch != "" {
+		watchList, err = parseWatchList(watch)
+		if err != nil {
+			log.Fatalf("invalid --watch: %v", err)
+		}
+	}
+
+	var filterCAN canFilter
+	if filterCANSpec != "" {
+		filterCAN, err = parseCANFilter(filterCANSpec)
+		if err != nil {
+			log.Fatalf("invalid --filter-can: %v", err)
+		}
+	}
+
+	var aesKey []byte
+	if key != "" {
+		aesKey, err = parseAESKey(key)
+		if err != nil {
+			log.Fatalf("invalid --key: %v", err)
+		}
+	}
+
+	var scramblerKey uint32
+	if scrambleKey != "" {
+		scramblerKey, err = parseScramblerKey(scrambleKey)
+		if err != nil {
+			log.Fatalf("invalid --scramble-key: %v", err)
+		}
+	}
+
+	if connectAttempts < 0 {
+		log.Fatalf("invalid --connect-attempts: %d", connectAttempts)
+	}
+
+	if once && replayPath != "" {
+		log.Fatalf("--once can't be combined with --replay")
+	}
+
+	if once && onceTimeout <= 0 {
+		log.Fatalf("invalid --once-timeout: %s", onceTimeout)
+	}
+
+	if noAudio && speakers {
+		log.Fatalf("--no-audio can't be combined with --speakers")
+	}
+
+	if jsonEventsOut && stdoutPCM {
+		log.Fatalf("--json-events can't be combined with --stdout-pcm, both write to stdout")
+	}
+
+	if speakers && !stdoutPCM && fifoPath == "" {
+		log.Fatalf("--speakers needs --stdout-pcm or --fifo, audio is played on the speakers by default")
+	}
+
+	if pingInterval < 0 {
+		log.Fatalf("invalid --ping-interval: %s", pingInterval)
+	}
+
+	if maxTalk < 0 {
+		log.Fatalf("invalid --max-talk: %s", maxTalk)
+	}
+
+	if duration < 0 {
+		log.Fatalf("invalid --duration: %s", duration)
+	}
+
+	if duration > 0 && replayPath != "" {
+		log.Fatalf("--duration can't be combined with --replay")
+	}
+
+	if replayPath != "" && capturePath != "" {
+		log.Fatalf("--replay can't be combined with --capture")
+	}
+
+	if activityFormat != ActivityFormatCSV && activityFormat != ActivityFormatJSONL {
+		log.Fatalf("invalid --activity-format: %s (supported: csv, jsonl)", activityFormat)
+	}
+
+	config := ClientConfig{
+		Network:        network,
+		CodecMode:      codecMode,
+		JitterDelay:    time.Duration(jitterMs) * time.Millisecond,
+		Conceal:        conceal,
+		ActivityLog:    activityLog,
+		ActivityFormat: activityFormat,
+		SMSLog:         smsLogPath,
+		Timeout:        timeout,
+		Volume:         volume,
+		AudioBackend:   audioBackend,
+		AudioBuffer:    audioBuffer,
+		OutputRate:     outputRate,
+		MetricsAddr:    metricsAddr,
+		HTTPStreamAddr: httpStreamAddr,
+		Webhook:        webhookURL,
+		Watch:          watchList,
+		FilterCAN:      filterCAN,
+		OnlySRC:        onlySRC,
+		Reconnect:      reconnect,
+		Capture:        capturePath,
+		ControlSocket:  controlSocket,
+		SaveLSF:        lsfDir,
+		MaxAttempts:    connectAttempts,
+		Once:           once,
+		OnceTimeout:    onceTimeout,
+		Duration:       duration,
+		MaxTalk:        maxTalk,
+		PingInterval:   pingInterval,
+		DebugFrames:    debugFrames,
+		LocalAddr:      laddr,
+		Key:            aesKey,
+		ScramblerKey:   scramblerKey,
+	}
+	if replayPath != "" && replayFast {
+		// Frames arrive in order and faster than real time, buffering them
+		// would only drop audio
+		config.JitterDelay = 0
+	}
+	if stdoutPCM {
+		config.PCMOutput = os.Stdout
+	}
+	config.FIFO = fifoPath
+	config.Speakers = speakers
+	config.NoAudio = noAudio
+	config.NoChime = noChime
+	if compact {
+		// The TUI owns the terminal, so the lines go where its logs go
+		config.CompactOutput = consoleOutput
+		if useTUI {
+			config.CompactOutput = logOutput
+		}
+	}
+	config.Filter = filter
+	config.FilterCutoff = filterCutoff
+	config.NoiseGate = noiseGate
+	config.Squelch = squelchDB
+
+	relayAddr := fileCfg.Address
+	if len(flag.Args()) >= 1 {
+		relayAddr = flag.Arg(0)
+	}
+
+	// A connect link carries the address, module and callsign at once
+	var uri connectURI
+	if len(flag.Args()) >= 1 && isConnectURI(relayAddr) {
+		if len(flag.Args()) == 2 {
+			log.Fatalf("the module letter can't be given after an %s:// link", m17URIScheme)
+		}
+		uri, err = parseConnectURI(relayAddr)
+		if err != nil {
+			log.Fatalf("invalid %s link: %v", m17URIScheme, err)
+		}
+		relayAddr = uri.Address
 	}
 
-	relayAddr := flag.Arg(0)
 	var moduleLetter byte
-	if len(flag.Args()) == 2 {
-		moduleLetter = flag.Arg(1)[0]
+	if uri.Address != "" {
+		moduleLetter = uri.Module
+	} else if len(flag.Args()) == 2 {
+		moduleLetter, err = parseModule(flag.Arg(1))
+		if err != nil {
+			log.Fatalf("invalid module letter: %v\nUsage: %s [options] <address|reflector> [module_letter]", err, os.Args[0])
+		}
+	} else if fileCfg.ModuleLetter != 0 {
+		moduleLetter = fileCfg.ModuleLetter
 	} else {
 		moduleLetter = ' ' // Default to space character
 	}
 
-	// Generate random callsign
-	callsign := generateRandomCallsign()
+	// Use the given callsign, the one from the link or generate a random one
+	if callsign == "" {
+		callsign = uri.Callsign
+	}
+	if callsign != "" {
+		callsign = strings.ToUpper(callsign)
+		if _, err := m17.EncodeCallsign(callsign); err != nil {
+			log.Fatalf("invalid --callsign: %v", err)
+		}
+	} else {
+		callsign = generateRandomCallsign()
+	}
+
+	// The settings are valid, log with levels from here on
+	setLogOutput(consoleOutput)
+	if jsonEventsOut {
+		jsonEvents = newEventWriter(os.Stdout)
+	}
+
+	// Select the audio output device, falling back to the default device
+	if device != "" {
+		if err := selectAudioDevice(device); err != nil {
+			slog.Warn("using the default audio device", "err", err)
+		}
+	}
+
+	// Check Codec 2 and the audio output without connecting
+	if selfTest {
+		client, err := NewClient(callsign, relayAddr, moduleLetter, config)
+		if err != nil {
+			fatal("self-test failed", "err", err)
+		}
+		err = runSelfTest(client)
+		client.Close()
+		if err != nil {
+			fatal("self-test failed", "err", err)
+		}
+		fmt.Fprintln(os.Stderr, "self-test passed")
+		return
+	}
+
+	// Split off the fallback addresses and look up reflector designators
+	// such as M17-USA in the directory
+	relays := strings.Split(relayAddr, ",")
+	for i, relay := range relays {
+		relay = strings.TrimSpace(relay)
+		if relay == "" && len(relays) > 1 {
+			fatal("empty address in the relay/reflector list", "addresses", relayAddr)
+		}
+		if replayPath == "" && isReflectorName(relay) {
+			addr, err := resolveReflector(relay, directoryURL, network)
+			if err != nil {
+				fatal("failed to resolve reflector", "name", relay, "err", err)
+			}
+			slog.Info("resolved reflector", "name", relay, "addr", addr)
+			relay = addr
+		}
+		relays[i] = relay
+	}
+	relayAddr = relays[0]
+	config.Fallbacks = relays[1:]
+
+	client, err := NewClient(callsign, relayAddr, moduleLetter, config)
+	if err != nil {
+		fatal("failed to create client", "err", err)
+	}
+
+	// quit is closed when the user exits from the TUI
+	quit := make(chan struct{})
 
 	// Initialize TUI
 	if useTUI {
-		err := termbox.Init()
-		if err != nil {
-			log.Fatalf("failed to initialize termbox: %v", err)
+		if err := termbox.Init(); err != nil {
+			slog.Warn("failed to initialize the TUI, running headless", "err", err)
+			useTUI = false
 		}
+	}
+	if useTUI {
 		defer termbox.Close()
 
-		// Redirect log output to io.Discard to disable logging to stdout
-		log.SetOutput(io.Discard)
+		// Redirect log output away from the terminal while the TUI is drawn
+		setLogOutput(logOutput)
+		tuiActive = true
+		drawTUI()
 
-		go func() {
-			for {
-				switch ev := termbox.PollEvent(); ev.Type {
-				case termbox.EventKey:
-					if ev.Key == termbox.KeyCtrlC {
-						return
-					}
-				case termbox.EventError:
-					log.Printf("termbox error: %v", ev.Err)
-				}
-			}
-		}()
+		go handleTUIEvents(client, quit)
+	}
+
+	run := func() bool { return runClient(client, quit) }
+	if replayPath != "" {
+		run = func() bool {
+			runReplay(client, replayPath, !replayFast, quit)
+			return true
+		}
 	}
 
 	if useGUI {
-		go func() {
-			// Redirect log output to io.Discard to disable logging to stdout
-			log.SetOutput(io.Discard)
+		// Redirect log output away from the terminal while the GUI is shown
+		setLogOutput(logOutput)
 
-			client, err := NewClient(callsign, relayAddr, moduleLetter)
-			if err != nil {
-				log.Fatalf("failed to create client: %v", err)
+		go func() {
+			ok := run()
+			if !ok {
+				os.Exit(1)
 			}
-			err = client.sendLSTN()
-			if err != nil {
-				log.Fatalf("failed to send LSTN packet: %v", err)
+			// Close the window too when the run ended on its own rather
+			// than by the user
+			if once || client.stopRequested() {
+				os.Exit(0)
 			}
-			go client.listen()
+		}()
+		startGUI(client, themeVariant, configPath)
+	} else if !run() {
+		// os.Exit skips the deferred cleanup
+		if useTUI {
+			termbox.Close()
+		}
+		os.Exit(1)
+	}
+}
 
-			sigChan := make(chan os.Signal, 1)
-			signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-			select {
-			case <-sigChan:
-				log.Println("Shutting down client...")
-			case <-func() chan struct{} {
-				done := make(chan struct{})
-				go func() {
-					termbox.PollEvent()
-					close(done)
-				}()
-				return done
-			}():
-				log.Println("GUI closed, shutting down client...")
-			}
-			client.sendDISC()
+// runClient connects the client and runs it until a termination signal is
+// received, the user quits or --duration has passed, or in --once mode until
+// the first transmission has been received, then disconnects from the
+// relay/reflector. It reports false when --once timed out without a
+// transmission.
+func runClient(client *Client, quit <-chan struct{}) bool {
+	defer client.Close()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	// The duration is wall-clock time, including connecting
+	var deadline <-chan time.Time
+	if client.duration > 0 {
+		deadline = time.After(client.duration)
+	}
+
+	// Connect in the background so the user can quit while retrying
+	connected := make(chan error, 1)
+	go func() {
+		connected <- client.connect()
+	}()
+	select {
+	case err := <-connected:
+		if err != nil {
+			slog.Error("failed to connect", "err", err)
+			updateField("Error", fmt.Sprintf("failed to connect: %v", err))
 			client.cancel()
-			select {
-			case <-client.discChan:
-				log.Println("Received DISC packet from relay, exiting...")
-			case <-time.After(5 * time.Second):
-				log.Println("Timeout waiting for DISC packet, exiting...")
-			}
-		}()
-		startGUI()
+			return false
+		}
+	case <-sigChan:
+		slog.Info("Shutting down client...")
+		client.cancel()
+		return true
+	case <-quit:
+		slog.Info("TUI closed, shutting down client...")
+		client.cancel()
+		return true
+	case <-deadline:
+		slog.Info("Duration reached while connecting, shutting down client...", "duration", client.duration)
+		client.cancel()
+		return true
+	case <-client.stop:
+		slog.Info("Disconnect requested, shutting down client...")
+		client.cancel()
+		return true
+	}
+	client.startReceiver(client.listen)
+	go func() {
+		if err := client.awaitACKN(); err != nil && !errors.Is(err, context.Canceled) {
+			client.fail(fmt.Errorf("relay/reflector didn't accept the connection: %w", err))
+		}
+	}()
+
+	var onceTimeout <-chan time.Time
+	if client.transmitted != nil {
+		onceTimeout = time.After(client.onceTimeout)
+	}
+	ok := true
+	select {
+	case <-sigChan:
+		slog.Info("Shutting down client...")
+	case <-quit:
+		slog.Info("TUI closed, shutting down client...")
+	case <-client.transmitted:
+		slog.Info("Transmission received, shutting down client...")
+	case <-onceTimeout:
+		slog.Error("No transmission received, shutting down client...", "timeout", client.onceTimeout)
+		ok = false
+	case <-deadline:
+		slog.Info("Duration reached, shutting down client...", "duration", client.duration)
+	case <-client.stop:
+		if err := client.failure(); err != nil {
+			slog.Error("Shutting down client...", "err", err)
+			ok = false
+		} else {
+			slog.Info("Disconnect requested, shutting down client...")
+		}
+	}
+
+	// Keep reading until the relay/reflector acknowledges the DISC
+	if client.disconnect() {
+		slog.Info("Received DISC packet from relay, exiting...")
 	} else {
-		client, err := NewClient(callsign, relayAddr, moduleLetter)
+		slog.Warn("Timeout waiting for DISC packet, exiting...")
+	}
+	client.cancel()
+	return ok
+}
+
+// runReplay replays a capture file until it ends, a termination signal is
+// received or the user quits
+func runReplay(client *Client, path string, realtime bool, quit <-chan struct{}) {
+	defer client.Close()
+
+	done := make(chan error, 1)
+	client.startReceiver(func() {
+		done <- client.replay(path, realtime)
+	})
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-done:
 		if err != nil {
-			log.Fatalf("failed to create client: %v", err)
+			slog.Error("failed to replay", "file", path, "err", err)
+			updateField("Error", fmt.Sprintf("failed to replay %s: %v", path, err))
 		}
-		err = client.sendLSTN()
-		if err != nil {
-			log.Fatalf("failed to send LSTN packet: %v", err)
-		}
-		go client.listen()
-
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-		select {
-		case <-sigChan:
-			log.Println("Shutting down client...")
-		case <-func() chan struct{} {
-			done := make(chan struct{})
-			go func() {
-				termbox.PollEvent()
-				close(done)
-			}()
-			return done
-		}():
-			log.Println("TUI closed, shutting down client...")
-		}
-		client.sendDISC()
-		client.cancel()
-		select {
-		case <-client.discChan:
-			log.Println("Received DISC packet from relay, exiting...")
-		case <-time.After(5 * time.Second):
-			log.Println("Timeout waiting for DISC packet, exiting...")
+		updateField("Status", "Replay finished")
+
+		// Keep the TUI up until the user quits
+		if tuiActive {
+			select {
+			case <-sigChan:
+			case <-quit:
+			case <-client.stop:
+			}
 		}
+	case <-sigChan:
+		slog.Info("Stopping replay...")
+	case <-quit:
+		slog.Info("TUI closed, stopping replay...")
+	case <-client.stop:
+		slog.Info("Stop requested, stopping replay...")
 	}
+	client.cancel()
 }
@@ -0,0 +1,128 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// GNSS metadata flag bits
+const (
+	gnssSouth         = 0x01
+	gnssWest          = 0x02
+	gnssAltitudeValid = 0x04
+)
+
+// gnssMetaLength is the length of GNSS position metadata
+const gnssMetaLength = 14
+
+// decodeGNSS decodes GNSS position metadata into a human readable position.
+// Latitude and longitude are stored as whole degrees followed by a 16-bit
+// fraction of a degree, and altitude as feet offset by 1500.
+func decodeGNSS(meta []byte) (string, bool) {
+	if len(meta) < gnssMetaLength {
+		return "", false
+	}
+
+	flags := meta[8]
+	lat := float64(meta[2]) + float64(binary.BigEndian.Uint16(meta[3:5]))/65536
+	lon := float64(meta[5]) + float64(binary.BigEndian.Uint16(meta[6:8]))/65536
+	if lat > 90 || lon > 180 {
+		return "", false
+	}
+	if flags&gnssSouth != 0 {
+		lat = -lat
+	}
+	if flags&gnssWest != 0 {
+		lon = -lon
+	}
+
+	position := fmt.Sprintf("%.5f, %.5f", lat, lon)
+	if flags&gnssAltitudeValid != 0 {
+		altitude := int(binary.BigEndian.Uint16(meta[9:11])) - 1500
+		position += fmt.Sprintf(", %d ft", altitude)
+	}
+	return position, true
+}
+
+// aesNonceLength is the length of the AES-CTR nonce carried in the META field
+// of an AES encrypted stream
+const aesNonceLength = 14
+
+// parseNonce returns the AES-CTR nonce from the META field of an AES
+// encrypted stream, reporting false when META is too short to hold one
+func parseNonce(meta []byte) ([]byte, bool) {
+	if len(meta) < aesNonceLength {
+		return nil, false
+	}
+	return meta[:aesNonceLength], true
+}
+
+// Text metadata layout
+const (
+	metaTextBlocks    = 4  // Maximum number of blocks in a message
+	metaTextBlockSize = 13 // Text bytes carried per block
+)
+
+// metaText accumulates text metadata blocks for a stream. The first META
+// byte holds a bitmask of the blocks making up the message in the high
+// nibble and the bit of the current block in the low nibble.
+type metaText struct {
+	streamID uint16
+	blocks   [metaTextBlocks][]byte
+}
+
+// add stores a text metadata block and returns the text received so far for
+// the stream
+func (t *metaText) add(streamID uint16, meta []byte) string {
+	if streamID != t.streamID {
+		*t = metaText{streamID: streamID}
+	}
+	if len(meta) < 1+metaTextBlockSize {
+		return t.String()
+	}
+
+	control := meta[0]
+	total := control >> 4
+	for i := 0; i < metaTextBlocks; i++ {
+		if control&(1<<i) != 0 && total&(1<<i) != 0 {
+			t.blocks[i] = append([]byte(nil), meta[1:1+metaTextBlockSize]...)
+			break
+		}
+	}
+	return t.String()
+}
+
+// String returns the received text with non-printable characters removed
+func (t *metaText) String() string {
+	var b strings.Builder
+	for _, block := range t.blocks {
+		b.Write(block)
+	}
+	text := strings.ToValidUTF8(b.String(), "")
+	text = strings.Map(func(r rune) rune {
+		if !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, text)
+	return strings.TrimSpace(text)
+}
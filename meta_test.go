@@ -0,0 +1,73 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "testing"
+
+// TestDecodeGNSS decodes hand encoded META payloads for known positions
+func TestDecodeGNSS(t *testing.T) {
+	tests := []struct {
+		name string
+		meta []byte
+		want string
+		ok   bool
+	}{
+		{
+			// 42.5 N, 71.25 W at 500 ft
+			name: "north west with altitude",
+			meta: []byte{0x00, 0x00, 42, 0x80, 0x00, 71, 0x40, 0x00, gnssWest | gnssAltitudeValid, 0x07, 0xD0, 0x00, 0x00, 0x00},
+			want: "42.50000, -71.25000, 500 ft",
+			ok:   true,
+		},
+		{
+			// 33.75 S, 151.125 E
+			name: "south east",
+			meta: []byte{0x00, 0x00, 33, 0xC0, 0x00, 151, 0x20, 0x00, gnssSouth, 0x00, 0x00, 0x00, 0x00, 0x00},
+			want: "-33.75000, 151.12500",
+			ok:   true,
+		},
+		{
+			// 0.25 S, 0.5 W at 1000 ft below the offset
+			name: "south west below sea level",
+			meta: []byte{0x00, 0x00, 0, 0x40, 0x00, 0, 0x80, 0x00, gnssSouth | gnssWest | gnssAltitudeValid, 0x01, 0xF4, 0x00, 0x00, 0x00},
+			want: "-0.25000, -0.50000, -1000 ft",
+			ok:   true,
+		},
+		{
+			name: "latitude out of range",
+			meta: []byte{0x00, 0x00, 91, 0x00, 0x00, 10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+		{
+			name: "longitude out of range",
+			meta: []byte{0x00, 0x00, 10, 0x00, 0x00, 181, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+		{
+			name: "too short",
+			meta: []byte{0x00, 0x00, 42, 0x80, 0x00, 71},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := decodeGNSS(tt.meta)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("decodeGNSS() = %q, %v, want %q, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
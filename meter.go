@@ -0,0 +1,95 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level meter settings
+const (
+	meterInterval = 100 * time.Millisecond // Display refresh interval
+	meterDecay    = 0.7                    // Level retained per interval once audio stops
+	meterFloorDB  = -60.0                  // Level shown as an empty meter
+	meterWidth    = 30                     // Width of the TUI bar in characters
+)
+
+// levelMeter tracks the audio level of decoded frames for display
+type levelMeter struct {
+	mu    sync.Mutex
+	level float64
+}
+
+// add records the level of a decoded frame
+func (m *levelMeter) add(audio []int16) {
+	level := frameLevel(audio)
+	m.mu.Lock()
+	m.level = math.Max(m.level, level)
+	m.mu.Unlock()
+}
+
+// run periodically shows the level, letting it decay when audio stops
+func (m *levelMeter) run(ctx context.Context) {
+	ticker := time.NewTicker(meterInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		m.mu.Lock()
+		level := m.level
+		m.level *= meterDecay
+		m.mu.Unlock()
+
+		updateTUI("Level", meterBar(level))
+		updateGUILevel(level)
+	}
+}
+
+// frameLevel returns the RMS level of an audio frame, scaled from 0 at
+// meterFloorDB to 1 at full scale
+func frameLevel(audio []int16) float64 {
+	if len(audio) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, sample := range audio {
+		s := float64(sample) / math.MaxInt16
+		sum += s * s
+	}
+	rms := math.Sqrt(sum / float64(len(audio)))
+	if rms == 0 {
+		return 0
+	}
+	db := 20 * math.Log10(rms)
+	return math.Max(0, math.Min(1, 1-db/meterFloorDB))
+}
+
+// meterBar renders a level as a bar of block characters
+func meterBar(level float64) string {
+	filled := int(math.Round(level * meterWidth))
+	return strings.Repeat("█", filled) + strings.Repeat("░", meterWidth-filled)
+}
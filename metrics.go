@@ -0,0 +1,148 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the Prometheus collectors updated as packets and frames are
+// received. A nil *metrics is valid and records nothing, so metrics cost
+// nothing when disabled.
+type metrics struct {
+	registry      *prometheus.Registry
+	packets       *prometheus.CounterVec
+	framesDecoded prometheus.Counter
+	crcFailures   prometheus.Counter
+	streamActive  prometheus.Gauge
+	lastSource    *prometheus.GaugeVec
+}
+
+// newMetrics creates the collectors in their own registry, with
+// keepaliveAge reporting the time since the last packet when scraped
+func newMetrics(keepaliveAge func() time.Duration) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		packets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "m17_packets_received_total",
+			Help: "Packets received from the relay/reflector by type.",
+		}, []string{"type"}),
+		framesDecoded: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "m17_frames_decoded_total",
+			Help: "Voice frames decoded.",
+		}),
+		crcFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "m17_crc_failures_total",
+			Help: "Frames ignored because of a bad CRC.",
+		}),
+		streamActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "m17_stream_active",
+			Help: "Whether a stream is currently being received.",
+		}),
+		lastSource: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "m17_last_source_info",
+			Help: "Source callsign of the most recent stream.",
+		}, []string{"src"}),
+	}
+	m.registry.MustRegister(
+		m.packets,
+		m.framesDecoded,
+		m.crcFailures,
+		m.streamActive,
+		m.lastSource,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "m17_keepalive_age_seconds",
+			Help: "Seconds since the last packet from the relay/reflector.",
+		}, func() float64 { return keepaliveAge().Seconds() }),
+	)
+	return m
+}
+
+// packet counts a received packet by its magic
+func (m *metrics) packet(magic string) {
+	if m == nil {
+		return
+	}
+	m.packets.WithLabelValues(strings.TrimSpace(magic)).Inc()
+}
+
+// frameDecoded counts a decoded voice frame
+func (m *metrics) frameDecoded() {
+	if m == nil {
+		return
+	}
+	m.framesDecoded.Inc()
+}
+
+// crcFailure counts a frame with a bad CRC
+func (m *metrics) crcFailure() {
+	if m == nil {
+		return
+	}
+	m.crcFailures.Inc()
+}
+
+// streamStarted marks a stream from src as active
+func (m *metrics) streamStarted(src string) {
+	if m == nil {
+		return
+	}
+	m.streamActive.Set(1)
+	m.lastSource.Reset()
+	m.lastSource.WithLabelValues(src).Set(1)
+}
+
+// streamEnded marks the current stream as finished
+func (m *metrics) streamEnded() {
+	if m == nil {
+		return
+	}
+	m.streamActive.Set(0)
+}
+
+// handler returns the HTTP handler serving the metrics
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// keepaliveAge returns the time since the last packet from the
+// relay/reflector
+func (c *Client) keepaliveAge() time.Duration {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	return time.Since(c.lastRx)
+}
+
+// serveMetrics serves the client metrics over HTTP at /metrics
+func (c *Client) serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", c.metrics.handler())
+
+	slog.Info("Serving metrics", "url", fmt.Sprintf("http://%s/metrics", addr))
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		slog.Error("failed to serve metrics", "err", err)
+		updateField("Error", fmt.Sprintf("failed to serve metrics: %v", err))
+	}
+}
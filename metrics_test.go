@@ -0,0 +1,73 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMetricsHandler checks the recorded counters and gauges are served in
+// the Prometheus text format, with label values escaped
+func TestMetricsHandler(t *testing.T) {
+	m := newMetrics(func() time.Duration { return 1500 * time.Millisecond })
+	m.packet("M17 ")
+	m.packet("M17 ")
+	m.packet("PING")
+	m.frameDecoded()
+	m.crcFailure()
+	m.streamStarted("N0CALL")
+	m.streamStarted(`BAD"SRC\`)
+
+	rec := httptest.NewRecorder()
+	m.handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`m17_packets_received_total{type="M17"} 2`,
+		`m17_packets_received_total{type="PING"} 1`,
+		`m17_frames_decoded_total 1`,
+		`m17_crc_failures_total 1`,
+		`m17_stream_active 1`,
+		`m17_last_source_info{src="BAD\"SRC\\"} 1`,
+		`m17_keepalive_age_seconds 1.5`,
+	} {
+		if !strings.Contains(string(body), want+"\n") {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(string(body), "N0CALL") {
+		t.Errorf("metrics still show the previous source:\n%s", body)
+	}
+}
+
+// TestNilMetrics checks a nil collector records nothing without panicking
+func TestNilMetrics(t *testing.T) {
+	var m *metrics
+	m.packet("M17 ")
+	m.frameDecoded()
+	m.crcFailure()
+	m.streamStarted("N0CALL")
+	m.streamEnded()
+}
@@ -0,0 +1,188 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"go-m17-listen/m17"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Packet mode protocol identifiers, the first byte of packet data
+const (
+	PacketRAW     = 0x00
+	PacketAX25    = 0x01
+	PacketAPRS    = 0x02
+	Packet6LoWPAN = 0x03
+	PacketIPv4    = 0x04
+	PacketSMS     = 0x05
+	PacketWinlink = 0x06
+)
+
+// packetProtocolNames maps packet mode protocol identifiers to their names
+var packetProtocolNames = map[byte]string{
+	PacketRAW:     "RAW",
+	PacketAX25:    "AX.25",
+	PacketAPRS:    "APRS",
+	Packet6LoWPAN: "6LoWPAN",
+	PacketIPv4:    "IPv4",
+	PacketSMS:     "SMS",
+	PacketWinlink: "Winlink",
+}
+
+// maxPacketFrames limits how many frames a packet mode transfer may span,
+// enough for the largest 825-byte packet in 16-byte payloads
+const maxPacketFrames = 52
+
+// packetAssembler collects the payloads of packet mode frames carried in
+// stream frames until the end of stream frame arrives
+type packetAssembler struct {
+	streamID uint16
+	frames   map[uint16][]byte
+}
+
+// add stores a frame's payload and returns the reassembled packet data once
+// the end of stream frame has been added. Frames from a new stream discard
+// any incomplete packet.
+func (a *packetAssembler) add(streamID, frameNumber uint16, payload []byte) ([]byte, error) {
+	if a.frames == nil || streamID != a.streamID {
+		a.streamID = streamID
+		a.frames = make(map[uint16][]byte)
+	}
+
+	seq := frameNumber & m17.FrameNumberMask
+	if len(a.frames) >= maxPacketFrames {
+		a.frames = nil
+		return nil, fmt.Errorf("packet spans more than %d frames", maxPacketFrames)
+	}
+	a.frames[seq] = append([]byte(nil), payload...)
+	if frameNumber&m17.FrameNumberEOS == 0 {
+		return nil, nil
+	}
+
+	// Join the payloads in frame order, every frame must be present
+	seqs := make([]int, 0, len(a.frames))
+	for s := range a.frames {
+		seqs = append(seqs, int(s))
+	}
+	sort.Ints(seqs)
+	frames := a.frames
+	a.frames = nil
+	var data []byte
+	for i, s := range seqs {
+		if s != i {
+			return nil, fmt.Errorf("packet is missing frame %d", i)
+		}
+		data = append(data, frames[uint16(s)]...)
+	}
+	return data, nil
+}
+
+// parsePacketData splits packet data into its protocol identifier and body,
+// checking the CRC that follows the body. Packet data carried in stream
+// frames is padded to a whole number of frames, so the end of the packet is
+// found by looking for a matching CRC.
+func parsePacketData(data []byte) (byte, []byte, error) {
+	for end := len(data); end >= 3; end-- {
+		if m17.CRC16(data[:end-2]) == binary.BigEndian.Uint16(data[end-2:end]) {
+			return data[0], data[1 : end-2], nil
+		}
+	}
+	return 0, nil, fmt.Errorf("packet CRC mismatch")
+}
+
+// describePacket returns a readable summary of a packet
+func describePacket(protocol byte, body []byte) string {
+	name, ok := packetProtocolNames[protocol]
+	if !ok {
+		name = fmt.Sprintf("Protocol 0x%02X", protocol)
+	}
+	switch protocol {
+	case PacketSMS:
+		return fmt.Sprintf("%s: %s", name, smsText(body))
+	case PacketAPRS:
+		return fmt.Sprintf("%s: %s", name, escapeText(strings.TrimSpace(string(body))))
+	}
+	return fmt.Sprintf("%s: %d bytes", name, len(body))
+}
+
+// handlePacketMode reassembles packet mode data sent in stream frames and
+// shows it once complete
+func (c *Client) handlePacketMode(streamID, frameNumber uint16, src, dst string, payload []byte) {
+	data, err := c.packets.add(streamID, frameNumber, payload)
+	if err != nil {
+		slog.Warn("failed to reassemble packet", "err", err)
+		updateField("Error", fmt.Sprintf("failed to reassemble packet: %v", err))
+		return
+	}
+	if data != nil {
+		c.showPacket(data, src, dst)
+	}
+}
+
+// handleM17P handles an M17P packet, which carries a whole packet mode
+// transfer after its link setup frame
+func (c *Client) handleM17P(packet []byte) {
+	if len(packet) < 37 {
+		slog.Warn("invalid M17P packet length", "length", len(packet))
+		updateField("Error", fmt.Sprintf("invalid M17P packet length: %d", len(packet)))
+		return
+	}
+
+	// Verify the CRC over the link setup frame
+	lsf, err := m17.ParseLSF(packet[4:34])
+	if err != nil {
+		c.crcFailures++
+		c.metrics.crcFailure()
+		slog.Warn("ignoring M17P packet with bad CRC", "failures", c.crcFailures)
+		updateField("CRCFailures", fmt.Sprintf("%d", c.crcFailures))
+		return
+	}
+
+	if !c.canFilter.allows(lsf.Type.ChannelAccessNumber()) {
+		slog.Debug("Ignoring M17P packet on filtered channel access number", "can", lsf.Type.ChannelAccessNumber(), "src", lsf.SRC)
+		return
+	}
+
+	slog.Debug("Received M17P packet", "dst", lsf.DST, "src", lsf.SRC, "type", formatHex(uint16(lsf.Type)))
+	updateField("DST", formatDestination(lsf.DST))
+	updateField("SRC", lsf.SRC)
+
+	c.showPacket(packet[34:], lsf.SRC, lsf.DST)
+}
+
+// showPacket checks and shows reassembled packet data from src to dst
+func (c *Client) showPacket(data []byte, src, dst string) {
+	protocol, body, err := parsePacketData(data)
+	if err != nil {
+		slog.Warn("ignoring packet", "err", err)
+		updateField("Error", fmt.Sprintf("ignoring packet: %v", err))
+		return
+	}
+
+	message := describePacket(protocol, body)
+	slog.Info("Received packet", "src", src, "dst", dst, "message", message)
+	updateField("Message", message)
+	if protocol == PacketSMS {
+		c.handleSMS(smsMessage{Time: time.Now(), SRC: src, DST: dst, Text: smsText(body)})
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"go-m17-listen/m17"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// rttStats measures the round-trip time to the relay/reflector from the
+// PINGs the client sends and the PONGs answering them. Only one PING is
+// outstanding at a time, so a PING still unanswered when the next one is
+// sent counts as lost. A nil *rttStats is valid and ignores PONGs.
+type rttStats struct {
+	mu          sync.Mutex
+	sentAt      time.Time
+	outstanding bool
+	sent        int
+	lost        int
+	replies     int
+	min         time.Duration
+	max         time.Duration
+	total       time.Duration
+}
+
+// ping records that a PING is being sent
+func (s *rttStats) ping(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.outstanding {
+		s.lost++
+	}
+	s.sent++
+	s.sentAt = now
+	s.outstanding = true
+}
+
+// pong records the PONG answering the outstanding PING, reporting whether
+// there was one
+func (s *rttStats) pong(now time.Time) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.outstanding {
+		return false
+	}
+	s.outstanding = false
+	rtt := now.Sub(s.sentAt)
+	if s.replies == 0 || rtt < s.min {
+		s.min = rtt
+	}
+	s.max = max(s.max, rtt)
+	s.total += rtt
+	s.replies++
+	return true
+}
+
+// String formats the round-trip times and PING loss for display, e.g.
+// "12/15/31 ms min/avg/max, 0.0% lost"
+func (s *rttStats) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	loss := 0.0
+	if s.sent > 0 {
+		loss = float64(s.lost) * 100 / float64(s.sent)
+	}
+	if s.replies == 0 {
+		return fmt.Sprintf("no replies, %.1f%% lost", loss)
+	}
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	return fmt.Sprintf("%.0f/%.0f/%.0f ms min/avg/max, %.1f%% lost",
+		ms(s.min), ms(s.total/time.Duration(s.replies)), ms(s.max), loss)
+}
+
+// sendPings sends a PING to the relay/reflector every interval to measure
+// the round-trip time until the client shuts down
+func (c *Client) sendPings(interval time.Duration) {
+	encodedCallsign, err := m17.EncodeCallsign(c.callsign)
+	if err != nil {
+		slog.Error("failed to encode callsign", "err", err)
+		return
+	}
+	packet := append([]byte(m17.MagicPING), encodedCallsign...)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		c.rtt.ping(time.Now())
+		if err := c.write(packet); err != nil {
+			slog.Error("failed to send PING packet", "err", err)
+			updateField("Error", fmt.Sprintf("failed to send PING packet: %v", err))
+		}
+		updateField("RTT", c.rtt.String())
+	}
+}
@@ -0,0 +1,189 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/cmplx"
+	"sync"
+)
+
+// Audio scope settings
+const (
+	scopeColumns       = 250 // Columns of history shown, 5 seconds at 50 columns per second
+	scopeColumnSamples = 160 // Samples summarized by each column, 20 ms at 8 kHz
+	scopeFFTSize       = 256 // Samples transformed for each spectrogram column
+	scopeBins          = 64  // Frequency rows of the spectrogram
+	scopeFloorDB       = -90 // Power shown as the spectrogram background
+)
+
+// audioScope keeps a downsampled history of decoded audio for display: the
+// minimum and maximum sample of each column for the waveform and the power
+// spectrum of each column for the spectrogram. Rendering only reads this
+// history, so its cost depends on the widget size and not the sample rate.
+type audioScope struct {
+	mu       sync.Mutex
+	peaks    [scopeColumns][2]float64
+	spectrum [scopeColumns][scopeBins]float64
+	next     int
+	samples  [scopeFFTSize]float64
+	pending  int
+	window   [scopeFFTSize]float64
+}
+
+// newAudioScope returns an empty audio scope
+func newAudioScope() *audioScope {
+	s := &audioScope{}
+	for i := range s.window {
+		s.window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(scopeFFTSize-1))
+	}
+	return s
+}
+
+// add appends decoded audio to the history, one column per
+// scopeColumnSamples samples. It is a no-op on a nil scope.
+func (s *audioScope) add(audio []int16) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(audio) > 0 {
+		n := min(len(audio), scopeColumnSamples-s.pending)
+		copy(s.samples[:], s.samples[n:])
+		for i, sample := range audio[:n] {
+			s.samples[scopeFFTSize-n+i] = float64(sample) / math.MaxInt16
+		}
+		audio = audio[n:]
+		s.pending += n
+		if s.pending == scopeColumnSamples {
+			s.addColumn()
+			s.pending = 0
+		}
+	}
+}
+
+// addColumn summarizes the latest samples into the next column
+func (s *audioScope) addColumn() {
+	low, high := 0.0, 0.0
+	for _, v := range s.samples[scopeFFTSize-scopeColumnSamples:] {
+		low = math.Min(low, v)
+		high = math.Max(high, v)
+	}
+	s.peaks[s.next] = [2]float64{low, high}
+
+	buf := make([]complex128, scopeFFTSize)
+	for i, v := range s.samples {
+		buf[i] = complex(v*s.window[i], 0)
+	}
+	fft(buf)
+
+	// Average neighbouring bins of the positive half down to scopeBins rows
+	// and scale each from scopeFloorDB to full scale
+	group := scopeFFTSize / 2 / scopeBins
+	for bin := 0; bin < scopeBins; bin++ {
+		var power float64
+		for _, v := range buf[bin*group : (bin+1)*group] {
+			m := cmplx.Abs(v) / scopeFFTSize
+			power += m * m
+		}
+		power /= float64(group)
+		level := 0.0
+		if power > 0 {
+			level = math.Max(0, math.Min(1, 1-10*math.Log10(power)/scopeFloorDB))
+		}
+		s.spectrum[s.next][bin] = level
+	}
+	s.next = (s.next + 1) % scopeColumns
+}
+
+// waveform renders the waveform history, oldest column on the left
+func (s *audioScope) waveform(w, h int, fg color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	if w <= 0 || h <= 0 {
+		return img
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mid := float64(h-1) / 2
+	for x := 0; x < w; x++ {
+		column := s.peaks[(s.next+x*scopeColumns/w)%scopeColumns]
+		top := int(math.Round(mid - column[1]*mid))
+		bottom := int(math.Round(mid - column[0]*mid))
+		for y := top; y <= bottom; y++ {
+			img.Set(x, y, fg)
+		}
+	}
+	return img
+}
+
+// spectrogram renders the spectrogram history, oldest column on the left
+// and low frequencies at the bottom
+func (s *audioScope) spectrogram(w, h int, fg color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	if w <= 0 || h <= 0 {
+		return img
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, g, b, _ := fg.RGBA()
+	for x := 0; x < w; x++ {
+		column := &s.spectrum[(s.next+x*scopeColumns/w)%scopeColumns]
+		for y := 0; y < h; y++ {
+			level := column[(h-1-y)*scopeBins/h]
+			img.Set(x, y, color.RGBA64{
+				R: uint16(float64(r) * level), G: uint16(float64(g) * level), B: uint16(float64(b) * level),
+				A: uint16(math.MaxUint16 * level),
+			})
+		}
+	}
+	return img
+}
+
+// fft computes the discrete Fourier transform of buf in place. The length of
+// buf must be a power of two.
+func fft(buf []complex128) {
+	n := len(buf)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			buf[i], buf[j] = buf[j], buf[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even, odd := buf[start+k], buf[start+k+size/2]*w
+				buf[start+k] = even + odd
+				buf[start+k+size/2] = even - odd
+				w *= step
+			}
+		}
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"go-m17-listen/codec2"
+	"math"
+	"os"
+	"time"
+)
+
+// Self-test tone settings
+const (
+	selfTestToneHz       = 440
+	selfTestToneDuration = time.Second
+	selfTestToneLevel    = 8000
+
+	// selfTestCodecDuration is how much of the tone is encoded and decoded
+	// in each Codec 2 mode. Only the second half is compared, once the
+	// codec has settled, and it holds a whole number of cycles of both
+	// selfTestToneHz and selfTestOffToneHz.
+	selfTestCodecDuration = 400 * time.Millisecond
+
+	// selfTestOffToneHz is a frequency the decoded tone should have little
+	// energy at, away from the harmonics of the tone
+	selfTestOffToneHz = 1000
+)
+
+// runSelfTest checks Codec 2 and the audio output without connecting to a
+// relay/reflector. Every supported Codec 2 mode encodes and decodes a tone,
+// then a tone is played through the same path as decoded audio. The results
+// are reported on stderr, keeping stdout clear for --stdout-pcm, and an
+// error is returned on the first failure.
+func runSelfTest(c *Client) error {
+	for _, bitrate := range codec2.Bitrates {
+		mode, err := codec2.ModeFromBitrate(bitrate)
+		if err != nil {
+			return err
+		}
+		if err := c.checkCodec2(mode); err != nil {
+			return fmt.Errorf("codec2 %d bps: %w", bitrate, err)
+		}
+		fmt.Fprintf(os.Stderr, "codec2 %d bps: ok\n", bitrate)
+	}
+
+	if c.audioErr != nil {
+		return fmt.Errorf("audio output: %w", c.audioErr)
+	}
+	if len(c.sinks) == 0 {
+		fmt.Fprintln(os.Stderr, "audio output: disabled")
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "audio output: playing a %s %dHz tone\n", selfTestToneDuration, selfTestToneHz)
+	for _, sink := range c.sinks {
+		sink.start()
+	}
+	frames := int(selfTestToneDuration / m17FrameInterval)
+	for i := 0; i < frames; i++ {
+		c.playAudio(selfTestTone(i*m17FrameSamples, m17FrameSamples))
+	}
+
+	// Closing the sinks waits for the queued tone to be played
+	for _, sink := range c.sinks {
+		sink.Close()
+		if err := sink.Err(); err != nil {
+			return fmt.Errorf("audio output: %s: %w", sink.name, err)
+		}
+	}
+	fmt.Fprintln(os.Stderr, "audio output: ok, the tone should have been heard")
+	return nil
+}
+
+// checkCodec2 encodes the self-test tone in the given Codec 2 mode, decodes
+// it with the decoder used for received streams and compares the result
+func (c *Client) checkCodec2(mode int) error {
+	encoder, err := codec2.New(mode)
+	if err != nil {
+		return err
+	}
+	defer encoder.Close()
+	decoder, err := c.decoderFor(mode)
+	if err != nil {
+		return err
+	}
+
+	frameSamples := encoder.SamplesPerFrame()
+	tone := selfTestTone(0, int(selfTestCodecDuration.Seconds()*codecSampleRate))
+	decoded := make([]int16, 0, len(tone))
+	for start := 0; start+frameSamples <= len(tone); start += frameSamples {
+		bits, err := encoder.Encode(tone[start : start+frameSamples])
+		if err != nil {
+			return err
+		}
+		audio, err := decoder.Decode(bits)
+		if err != nil {
+			return err
+		}
+		if len(audio) != decoder.SamplesPerFrame() {
+			return fmt.Errorf("decoded %d samples, expected %d", len(audio), decoder.SamplesPerFrame())
+		}
+		decoded = append(decoded, audio...)
+	}
+	return compareTone(tone, decoded)
+}
+
+// compareTone checks the decoded audio carries the self-test tone at about
+// the level it was sent, comparing the second half of each
+func compareTone(sent, decoded []int16) error {
+	if len(decoded) != len(sent) {
+		return fmt.Errorf("decoded %d samples, expected %d", len(decoded), len(sent))
+	}
+	sentLevel := toneAmplitude(sent[len(sent)/2:], selfTestToneHz)
+	level := toneAmplitude(decoded[len(decoded)/2:], selfTestToneHz)
+	offLevel := toneAmplitude(decoded[len(decoded)/2:], selfTestOffToneHz)
+
+	// Codec 2 is lossy, so allow the level 12dB either way
+	if level < sentLevel/4 || level > sentLevel*4 {
+		return fmt.Errorf("decoded %dHz tone at level %.0f, sent at %.0f", selfTestToneHz, level, sentLevel)
+	}
+	if offLevel > level/2 {
+		return fmt.Errorf("decoded audio has more %dHz than %dHz tone", selfTestOffToneHz, selfTestToneHz)
+	}
+	return nil
+}
+
+// selfTestTone returns n samples of the self-test tone starting at the given
+// sample
+func selfTestTone(start, n int) []int16 {
+	audio := make([]int16, n)
+	for i := range audio {
+		t := float64(start+i) / codecSampleRate
+		audio[i] = int16(selfTestToneLevel * math.Sin(2*math.Pi*selfTestToneHz*t))
+	}
+	return audio
+}
+
+// toneAmplitude returns the amplitude of the given frequency in the audio
+func toneAmplitude(audio []int16, hz float64) float64 {
+	var re, im float64
+	for i, sample := range audio {
+		phase := 2 * math.Pi * hz * float64(i) / codecSampleRate
+		re += float64(sample) * math.Cos(phase)
+		im += float64(sample) * math.Sin(phase)
+	}
+	return 2 * math.Hypot(re, im) / float64(len(audio))
+}
@@ -0,0 +1,77 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// TestCompareTone checks decoded audio is accepted only when it carries the
+// self-test tone at about the level it was sent
+func TestCompareTone(t *testing.T) {
+	n := int(selfTestCodecDuration.Seconds() * codecSampleRate)
+	tone := selfTestTone(0, n)
+	scaled := func(audio []int16, gain float64) []int16 {
+		out := make([]int16, len(audio))
+		for i, sample := range audio {
+			out[i] = int16(float64(sample) * gain)
+		}
+		return out
+	}
+	mixed := make([]int16, n)
+	for i, sample := range toneAt(selfTestOffToneHz, n) {
+		mixed[i] = tone[i]/2 + sample
+	}
+
+	tests := []struct {
+		name    string
+		decoded []int16
+		wantErr bool
+	}{
+		{"same tone", tone, false},
+		{"quieter tone", scaled(tone, 0.5), false},
+		{"delayed tone", append(make([]int16, 160), tone[:n-160]...), false},
+		{"silence", make([]int16, n), true},
+		{"much quieter tone", scaled(tone, 0.1), true},
+		{"other frequency", toneAt(selfTestOffToneHz, n), true},
+		{"mostly other frequency", mixed, true},
+		{"short", tone[:n/2], true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := compareTone(tone, tt.decoded)
+			if tt.wantErr && err == nil {
+				t.Error("compareTone succeeded, want an error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("compareTone failed: %v", err)
+			}
+		})
+	}
+}
+
+// toneAt returns n samples of a tone at the given frequency and the
+// self-test level
+func toneAt(hz float64, n int) []int16 {
+	audio := make([]int16, n)
+	for i := range audio {
+		audio[i] = int16(selfTestToneLevel * math.Sin(2*math.Pi*hz*float64(i)/codecSampleRate))
+	}
+	return audio
+}
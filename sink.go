@@ -0,0 +1,153 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// sinkQueueFrames is the number of frames queued for each audio sink. A sink
+// that falls further behind misses audio instead of holding up the others.
+const sinkQueueFrames = 50 // 2 seconds
+
+// speakersSink is the name of the sink playing to the sound card
+const speakersSink = "speakers"
+
+// audioSink is an output decoded audio is played or written to, such as the
+// speakers, stdout or a named pipe. Each sink writes from its own goroutine
+// so a slow sink doesn't block the others.
+type audioSink struct {
+	name      string
+	player    Player
+	resampler *resampler
+	lossless  bool
+	queue     chan []int16
+	closed    chan struct{}
+	done      chan struct{}
+	running   atomic.Bool
+	closeOnce sync.Once
+	errOnce   sync.Once
+	err       error
+}
+
+// newAudioSink creates a sink writing to player, resampling the audio first
+// when resample is set. A lossless sink, such as stdout piped to a recorder,
+// holds up playback instead of missing audio when it falls behind.
+func newAudioSink(name string, player Player, resample *resampler, lossless bool) *audioSink {
+	return &audioSink{
+		name:      name,
+		player:    player,
+		resampler: resample,
+		lossless:  lossless,
+		queue:     make(chan []int16, sinkQueueFrames),
+		closed:    make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// start starts writing queued audio from a goroutine
+func (s *audioSink) start() {
+	s.running.Store(true)
+	go s.run()
+}
+
+// run writes queued audio until the sink is closed
+func (s *audioSink) run() {
+	defer close(s.done)
+	for {
+		select {
+		case audio := <-s.queue:
+			s.report(s.play(audio))
+		case <-s.closed:
+			// Write what is left before the player is closed
+			for {
+				select {
+				case audio := <-s.queue:
+					s.report(s.play(audio))
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// write queues audio for the sink, dropping it when a lossy sink is full
+func (s *audioSink) write(audio []int16) {
+	if s.lossless {
+		select {
+		case s.queue <- audio:
+		case <-s.closed:
+		}
+		return
+	}
+	select {
+	case s.queue <- audio:
+	default:
+		slog.Debug("Dropping audio for slow sink", "sink", s.name)
+	}
+}
+
+// play resamples the audio and writes it to the player as 16-bit
+// little-endian samples
+func (s *audioSink) play(audio []int16) error {
+	// Upsample for devices that play 8kHz poorly
+	if s.resampler != nil {
+		audio = s.resampler.resample(audio)
+	}
+
+	buf := make([]byte, len(audio)*2)
+	for i, sample := range audio {
+		binary.LittleEndian.PutUint16(buf[i*2:], uint16(sample))
+	}
+	_, err := s.player.Write(buf)
+	return err
+}
+
+// report shows a failed write
+func (s *audioSink) report(err error) {
+	if err != nil {
+		s.errOnce.Do(func() { s.err = err })
+		slog.Error("failed to play audio", "sink", s.name, "err", err)
+		updateField("Error", fmt.Sprintf("failed to play audio on %s: %v", s.name, err))
+	}
+}
+
+// Err returns the first failed write. It is only valid once the sink is
+// closed.
+func (s *audioSink) Err() error {
+	return s.err
+}
+
+// Close writes any queued audio and closes the player, letting it finish
+// playing any buffered audio. It is safe to call more than once.
+func (s *audioSink) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		if s.running.Load() {
+			<-s.done
+		}
+		err = s.player.Close()
+	})
+	return err
+}
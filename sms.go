@@ -0,0 +1,124 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// smsHistoryLimit is the number of text messages kept for the Messages pane
+const smsHistoryLimit = 20
+
+// smsMessage is a received SMS packet
+type smsMessage struct {
+	Time time.Time
+	SRC  string
+	DST  string
+	Text string
+}
+
+// String formats the message as a single line with its time, source and
+// destination, e.g. "2024-11-30T12:00:00Z KC1AWV > BROADCAST: hello"
+func (m smsMessage) String() string {
+	return fmt.Sprintf("%s %s > %s: %s", m.Time.UTC().Format(time.RFC3339), m.SRC, m.DST, m.Text)
+}
+
+// smsText returns the text of an SMS packet body, which ends at the first
+// NUL if it is padded, with control characters escaped by escapeText
+func smsText(body []byte) string {
+	text, _, _ := strings.Cut(string(body), "\x00")
+	return escapeText(strings.TrimSpace(text))
+}
+
+// escapeText escapes control characters, invalid UTF-8 and backslashes in
+// text received over the air the way %q does, e.g. "\x1b" for ESC, so a
+// message can't move the cursor, recolor the terminal or add lines to a log
+// file. Other characters, quotes included, are kept as is.
+func escapeText(text string) string {
+	var b strings.Builder
+	for len(text) > 0 {
+		r, size := utf8.DecodeRuneInString(text)
+		switch {
+		case r == utf8.RuneError && size == 1:
+			fmt.Fprintf(&b, `\x%02x`, text[0])
+		case r == '\\':
+			b.WriteString(`\\`)
+		case unicode.IsControl(r):
+			quoted := strconv.QuoteRune(r)
+			b.WriteString(quoted[1 : len(quoted)-1])
+		default:
+			b.WriteRune(r)
+		}
+		text = text[size:]
+	}
+	return b.String()
+}
+
+// smsLog appends received text messages to a file, one per line
+type smsLog struct {
+	file *os.File
+}
+
+// newSMSLog opens the SMS log at path for appending
+func newSMSLog(path string) (*smsLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SMS log: %w", err)
+	}
+	return &smsLog{file: file}, nil
+}
+
+// write appends a message to the log. Messages are rare, so each one is
+// written straight to the file and survives a crash.
+func (l *smsLog) write(m smsMessage) error {
+	if l == nil {
+		return nil
+	}
+	_, err := fmt.Fprintln(l.file, m)
+	return err
+}
+
+// Close closes the SMS log
+func (l *smsLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// handleSMS logs a received text message and adds it to the Messages pane,
+// newest first
+func (c *Client) handleSMS(m smsMessage) {
+	if err := c.smsLog.write(m); err != nil {
+		slog.Error("failed to write SMS log", "err", err)
+		updateField("Error", fmt.Sprintf("failed to write SMS log: %v", err))
+	}
+
+	c.smsHistory = append([]string{m.String()}, c.smsHistory...)
+	if len(c.smsHistory) > smsHistoryLimit {
+		c.smsHistory = c.smsHistory[:smsHistoryLimit]
+	}
+	updateField("Messages", strings.Join(c.smsHistory, "\n"))
+}
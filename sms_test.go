@@ -0,0 +1,87 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSMSText checks the text of SMS bodies is cut at the padding and has
+// control characters escaped
+func TestSMSText(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"plain", "hello", "hello"},
+		{"padded", "hello\x00\x00\x00", "hello"},
+		{"surrounding whitespace", "  hello \r\n", "hello"},
+		{"unicode and quotes", `73 de "KC1AWV" ☺`, `73 de "KC1AWV" ☺`},
+		{"newline", "first\nsecond", `first\nsecond`},
+		{"carriage return", "fake\rline", `fake\rline`},
+		{"terminal escape", "\x1b[2J\x1b[31mred", `\x1b[2J\x1b[31mred`},
+		{"bell and delete", "ding\x07\x7f", `ding\a\x7f`},
+		{"C1 control", "next\u0085line", `next\u0085line`},
+		{"backslash", `C:\temp\n`, `C:\\temp\\n`},
+		{"invalid UTF-8", "bad\xff\xfebytes", `bad\xff\xfebytes`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := smsText([]byte(tt.body)); got != tt.want {
+				t.Errorf("smsText(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSMSLogOneLinePerMessage checks a message with embedded line breaks is
+// written to the SMS log as a single line
+func TestSMSLogOneLinePerMessage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sms.log")
+	l, err := newSMSLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 11, 30, 12, 0, 0, 0, time.UTC)
+	for _, body := range []string{"hello\n2024-11-30T12:00:00Z N0CALL > BROADCAST: forged", "bye"} {
+		if err := l.write(smsMessage{Time: at, SRC: "KC1AWV", DST: "BROADCAST", Text: smsText([]byte(body))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `2024-11-30T12:00:00Z KC1AWV > BROADCAST: hello\n2024-11-30T12:00:00Z N0CALL > BROADCAST: forged` + "\n" +
+		"2024-11-30T12:00:00Z KC1AWV > BROADCAST: bye\n"
+	if got := string(data); got != want {
+		t.Errorf("SMS log =\n%s\nwant\n%s", got, want)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("SMS log has %d lines, want 2", lines)
+	}
+}
@@ -0,0 +1,211 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"go-m17-listen/m17"
+	"sync"
+	"time"
+)
+
+// m17PayloadBits is the size of the payload of an M17 stream frame in bits
+const m17PayloadBits = 128
+
+// dedupWindow is the number of recent frame numbers remembered for the
+// current stream to drop frames a relay sends more than once
+const dedupWindow = 32
+
+// frameStats counts received, lost and duplicate frames using the M17 frame
+// number sequence
+type frameStats struct {
+	mu            sync.Mutex
+	streamID      uint16
+	active        bool
+	next          uint16
+	received      int
+	lost          int
+	totalReceived int
+	totalLost     int
+	first         time.Time // Arrival of the first frame of the stream
+	last          time.Time // Arrival of the latest frame of the stream
+
+	// Recent frame numbers of the stream from seenSRC with seenStream as its
+	// ID, kept past the end of the stream so a repeated end of stream frame
+	// is caught too
+	seenStream      uint16
+	seenSRC         string
+	seenAny         bool
+	seen            [dedupWindow]uint16
+	seenCount       int
+	duplicates      int
+	totalDuplicates int
+}
+
+// duplicate reports whether a frame with the same stream ID and frame number
+// was received recently, counting it, and otherwise remembers the frame. A
+// different source reusing the stream ID starts over.
+func (s *frameStats) duplicate(streamID, frameNumber uint16, src string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.seenAny || streamID != s.seenStream || src != s.seenSRC {
+		s.seenStream = streamID
+		s.seenSRC = src
+		s.seenAny = true
+		s.seenCount = 0
+	}
+	seq := frameNumber & m17.FrameNumberMask
+	for i := 0; i < min(s.seenCount, dedupWindow); i++ {
+		if s.seen[i] == seq {
+			s.duplicates++
+			return true
+		}
+	}
+	s.seen[s.seenCount%dedupWindow] = seq
+	s.seenCount++
+	return false
+}
+
+// add records a received frame, counting any frames skipped since the
+// previous one as lost
+func (s *frameStats) add(streamID, frameNumber uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active && streamID != s.streamID {
+		s.endLocked()
+	}
+
+	seq := frameNumber & m17.FrameNumberMask
+	if s.active {
+		// Ignore late or duplicate frames
+		if seq != s.next && frameBefore(seq, s.next) {
+			return
+		}
+		s.lost += int((seq - s.next) & m17.FrameNumberMask)
+	}
+
+	now := time.Now()
+	if !s.active {
+		s.first = now
+	}
+	s.last = now
+	s.streamID = streamID
+	s.active = true
+	s.received++
+	s.next = (seq + 1) & m17.FrameNumberMask
+}
+
+// end resets the per-stream counters, adding them to the totals
+func (s *frameStats) end() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.endLocked()
+}
+
+// endLocked resets the per-stream counters. The caller must hold s.mu.
+func (s *frameStats) endLocked() {
+	s.totalReceived += s.received
+	s.totalLost += s.lost
+	s.totalDuplicates += s.duplicates
+	s.received = 0
+	s.lost = 0
+	s.duplicates = 0
+	s.active = false
+}
+
+// lossPercent returns the share of frames lost in the current stream
+func (s *frameStats) lossPercent() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lost+s.received == 0 {
+		return 0
+	}
+	return float64(s.lost) * 100 / float64(s.lost+s.received)
+}
+
+// String returns the frame loss of the current stream
+func (s *frameStats) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return formatFrameLoss(s.lost, s.received, s.duplicates)
+}
+
+// rate returns the frames per second received in the current stream and the
+// matching payload bitrate, formatted for display. A steady stream runs at 25
+// fps, less means loss or a jittery relay.
+func (s *frameStats) rate() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elapsed := s.last.Sub(s.first).Seconds()
+	if s.received < 2 || elapsed <= 0 {
+		return ""
+	}
+	fps := float64(s.received-1) / elapsed
+	return fmt.Sprintf("%.1f fps, %.0f bps", fps, fps*m17PayloadBits)
+}
+
+// totals returns the frame loss over all streams, including the current one
+func (s *frameStats) totals() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return formatFrameLoss(s.totalLost+s.lost, s.totalReceived+s.received, s.totalDuplicates+s.duplicates)
+}
+
+// formatFrameLoss formats a lost frame count with the loss percentage,
+// followed by the number of dropped duplicates if there were any
+func formatFrameLoss(lost, received, duplicates int) string {
+	loss := "0 lost"
+	if lost+received > 0 {
+		loss = fmt.Sprintf("%d lost of %d (%.1f%%)", lost, lost+received, float64(lost)*100/float64(lost+received))
+	}
+	if duplicates > 0 {
+		loss += fmt.Sprintf(", %d duplicates", duplicates)
+	}
+	return loss
+}
+
+// decodeErrorInterval is how often decode errors are summarized
+const decodeErrorInterval = 5 * time.Second
+
+// decodeErrors counts errors decoding received frames between summaries, so
+// a lossy link doesn't log and show every malformed frame
+type decodeErrors struct {
+	mu     sync.Mutex
+	count  int
+	latest error
+}
+
+// add records a decode error
+func (d *decodeErrors) add(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.count++
+	d.latest = err
+}
+
+// take returns the number of errors recorded since the last call and the
+// latest of them, starting a new count
+func (d *decodeErrors) take() (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	count, latest := d.count, d.latest
+	d.count, d.latest = 0, nil
+	return count, latest
+}
@@ -0,0 +1,211 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HTTP stream settings. Each listener buffers up to streamListenerBuffer
+// frames and misses audio when it falls further behind. Silence is sent
+// after streamIdle without audio so players don't stall between
+// transmissions.
+const (
+	streamListenerBuffer = 50 // 2 seconds
+	streamIdle           = 200 * time.Millisecond
+)
+
+// audioStream fans decoded audio out to HTTP listeners. A nil *audioStream is
+// valid and does nothing, so streaming costs nothing when disabled.
+type audioStream struct {
+	mu        sync.Mutex
+	listeners map[chan []byte]struct{}
+	active    bool
+	src       string
+	dst       string
+}
+
+// streamStatus is the JSON document served at /status
+type streamStatus struct {
+	Active    bool   `json:"active"`
+	SRC       string `json:"src,omitempty"`
+	DST       string `json:"dst,omitempty"`
+	Listeners int    `json:"listeners"`
+}
+
+// newAudioStream creates an audio stream without listeners
+func newAudioStream() *audioStream {
+	return &audioStream{listeners: make(map[chan []byte]struct{})}
+}
+
+// write sends 8kHz audio to every listener, skipping listeners whose buffer
+// is full
+func (s *audioStream) write(audio []int16) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.listeners) == 0 {
+		return
+	}
+
+	buf := make([]byte, len(audio)*2)
+	for i, sample := range audio {
+		binary.LittleEndian.PutUint16(buf[i*2:], uint16(sample))
+	}
+	for ch := range s.listeners {
+		select {
+		case ch <- buf:
+		default:
+		}
+	}
+}
+
+// streamStarted records the source and destination of the active stream
+func (s *audioStream) streamStarted(src, dst string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.active, s.src, s.dst = true, src, dst
+	s.mu.Unlock()
+}
+
+// streamEnded marks the stream as ended
+func (s *audioStream) streamEnded() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.active = false
+	s.mu.Unlock()
+}
+
+// status returns the current stream status
+func (s *audioStream) status() streamStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := streamStatus{Active: s.active, Listeners: len(s.listeners)}
+	if s.active {
+		status.SRC, status.DST = s.src, s.dst
+	}
+	return status
+}
+
+// subscribe adds a listener
+func (s *audioStream) subscribe() chan []byte {
+	ch := make(chan []byte, streamListenerBuffer)
+	s.mu.Lock()
+	s.listeners[ch] = struct{}{}
+	s.mu.Unlock()
+	return ch
+}
+
+// unsubscribe removes a listener
+func (s *audioStream) unsubscribe(ch chan []byte) {
+	s.mu.Lock()
+	delete(s.listeners, ch)
+	s.mu.Unlock()
+}
+
+// wavStreamHeader returns a WAV header for an endless 8kHz 16-bit mono
+// stream, using the largest sizes since the length isn't known
+func wavStreamHeader() []byte {
+	h := make([]byte, 44)
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], 0xFFFFFFFF)
+	copy(h[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16)                // fmt chunk size
+	binary.LittleEndian.PutUint16(h[20:], 1)                 // PCM
+	binary.LittleEndian.PutUint16(h[22:], 1)                 // Channels
+	binary.LittleEndian.PutUint32(h[24:], codecSampleRate)   // Sample rate
+	binary.LittleEndian.PutUint32(h[28:], codecSampleRate*2) // Byte rate
+	binary.LittleEndian.PutUint16(h[32:], 2)                 // Block align
+	binary.LittleEndian.PutUint16(h[34:], 16)                // Bits per sample
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], 0xFFFFFFFF)
+	return h
+}
+
+// serveStream serves the live audio as an endless WAV stream at /stream.wav
+// and the current stream status as JSON at /status
+func (c *Client) serveStream(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream.wav", func(w http.ResponseWriter, r *http.Request) {
+		status := c.httpStream.status()
+		w.Header().Set("Content-Type", "audio/wav")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-M17-SRC", status.SRC)
+		w.Header().Set("X-M17-DST", status.DST)
+		if _, err := w.Write(wavStreamHeader()); err != nil {
+			return
+		}
+
+		ch := c.httpStream.subscribe()
+		defer c.httpStream.unsubscribe(ch)
+		slog.Info("HTTP stream listener connected", "addr", r.RemoteAddr)
+		defer slog.Info("HTTP stream listener disconnected", "addr", r.RemoteAddr)
+
+		flusher, _ := w.(http.Flusher)
+		silence := make([]byte, m17FrameSamples*2)
+		ticker := time.NewTicker(m17FrameInterval)
+		defer ticker.Stop()
+		lastAudio := time.Now()
+		for {
+			var buf []byte
+			select {
+			case <-r.Context().Done():
+				return
+			case <-c.ctx.Done():
+				return
+			case buf = <-ch:
+				lastAudio = time.Now()
+			case <-ticker.C:
+				if time.Since(lastAudio) < streamIdle {
+					continue
+				}
+				buf = silence
+			}
+			if _, err := w.Write(buf); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(c.httpStream.status()); err != nil {
+			slog.Warn("failed to write stream status", "err", err)
+		}
+	})
+
+	slog.Info("Serving audio stream", "url", fmt.Sprintf("http://%s/stream.wav", addr))
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		slog.Error("failed to serve audio stream", "err", err)
+		updateField("Error", fmt.Sprintf("failed to serve audio stream: %v", err))
+	}
+}
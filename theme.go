@@ -8,7 +8,18 @@ import (
 	"fyne.io/fyne/v2/theme"
 )
 
-type customTheme struct{}
+// Theme variants selectable with --theme
+const (
+	ThemeSystem = "system"
+	ThemeLight  = "light"
+	ThemeDark   = "dark"
+)
+
+// customTheme forces the light or dark variant regardless of the OS setting,
+// unless the variant is ThemeSystem
+type customTheme struct {
+	variant string
+}
 
 func (customTheme) Font(s fyne.TextStyle) fyne.Resource {
 	if s.Monospace {
@@ -26,7 +37,13 @@ func (customTheme) Font(s fyne.TextStyle) fyne.Resource {
 	return theme.DefaultTextFont()
 }
 
-func (customTheme) Color(n fyne.ThemeColorName, v fyne.ThemeVariant) color.Color {
+func (t customTheme) Color(n fyne.ThemeColorName, v fyne.ThemeVariant) color.Color {
+	switch t.variant {
+	case ThemeLight:
+		v = theme.VariantLight
+	case ThemeDark:
+		v = theme.VariantDark
+	}
 	return theme.DefaultTheme().Color(n, v)
 }
 
@@ -37,3 +54,12 @@ func (customTheme) Icon(n fyne.ThemeIconName) fyne.Resource {
 func (customTheme) Size(n fyne.ThemeSizeName) float32 {
 	return theme.DefaultTheme().Size(n)
 }
+
+// toggledTheme returns the variant opposite to the one shown for current,
+// using the system variant when current is ThemeSystem
+func toggledTheme(current string, system fyne.ThemeVariant) string {
+	if current == ThemeDark || (current == ThemeSystem && system == theme.VariantDark) {
+		return ThemeLight
+	}
+	return ThemeDark
+}
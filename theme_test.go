@@ -0,0 +1,57 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/theme"
+)
+
+// TestToggledTheme checks toggling always switches the variant shown, so
+// repeated toggles alternate even when starting from the system theme
+func TestToggledTheme(t *testing.T) {
+	tests := []struct {
+		current string
+		system  fyne.ThemeVariant
+		want    string
+	}{
+		{ThemeSystem, theme.VariantDark, ThemeLight},
+		{ThemeSystem, theme.VariantLight, ThemeDark},
+		{ThemeDark, theme.VariantDark, ThemeLight},
+		{ThemeDark, theme.VariantLight, ThemeLight},
+		{ThemeLight, theme.VariantDark, ThemeDark},
+		{ThemeLight, theme.VariantLight, ThemeDark},
+	}
+
+	for _, tt := range tests {
+		if got := toggledTheme(tt.current, tt.system); got != tt.want {
+			t.Errorf("toggledTheme(%q, %v) = %q, want %q", tt.current, tt.system, got, tt.want)
+		}
+	}
+
+	// Repeated toggles from the system theme keep alternating
+	variant := ThemeSystem
+	for i, want := range []string{ThemeLight, ThemeDark, ThemeLight} {
+		variant = toggledTheme(variant, theme.VariantDark)
+		if variant != want {
+			t.Errorf("toggle %d = %q, want %q", i+1, variant, want)
+		}
+	}
+}
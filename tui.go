@@ -19,7 +19,10 @@ package main
 
 import (
 	"fmt"
-	"strconv"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/nsf/termbox-go"
 )
@@ -31,30 +34,132 @@ var tuiData = map[string]string{
 	"DST":                   "",
 	"SRC":                   "",
 	"TYPE":                  "",
+	"TypeFlags":             "",
 	"META":                  "",
+	"Position":              "",
+	"Text":                  "",
+	"Message":               "",
+	"Encrypted":             "",
+	"Nonce":                 "",
 	"PacketStreamIndicator": "",
 	"DataTypeIndicator":     "",
 	"EncryptionType":        "",
 	"EncryptionSubtype":     "",
 	"ChannelAccessNumber":   "",
+	"CodecMode":             "",
+	"FrameLoss":             "",
+	"FrameRate":             "",
+	"TXDuration":            "",
 	"Payload":               "",
 	"Status":                "",
+	"Module":                "",
+	"Relay":                 "",
+	"Volume":                "",
+	"Level":                 "",
+	"LinkHealth":            "",
+	"RTT":                   "",
+	"Squelch":               "",
+	"Audio":                 "",
+	"CRCFailures":           "0",
 	"Error":                 "",
 }
 
-// updateTUI updates the TUI field with the given value
+// tuiChanged stores when the Status and Error fields last changed
+var tuiChanged = make(map[string]time.Time)
+
+// tuiLogSize is the number of status and error lines kept for the log pane
+const tuiLogSize = 500
+
+// tuiLog stores recent status and error lines shown in the log pane
+var tuiLog = newLogRing(tuiLogSize)
+
+// tuiLogOffset is how many lines the log pane is scrolled back from the end
+var tuiLogOffset int
+
+// tuiNoColor disables colored fields for terminals that render them poorly
+var tuiNoColor bool
+
+// tuiConnected tracks whether the relay/reflector connection is up, used to
+// color the Status field
+var tuiConnected bool
+
+// tuiAlert is set while a stream from a watched callsign is active, used to
+// highlight the Status and Source fields
+var tuiAlert bool
+
+// tuiActive is set once termbox has been initialized, drawing is skipped
+// until then so headless and GUI modes never touch the terminal
+var tuiActive bool
+
+// tuiShowHelp toggles the key binding help overlay
+var tuiShowHelp bool
+
+// tuiShowFrame toggles the frame details overlay
+var tuiShowFrame bool
+
+// tuiShowMessages toggles the text messages overlay
+var tuiShowMessages bool
+
+// tuiHelp lists the TUI key bindings shown in the help overlay
+var tuiHelp = []string{
+	"Key bindings",
+	"",
+	"?          Toggle this help",
+	"q, Ctrl-C  Quit",
+	"m          Mute/unmute audio",
+	"+, -       Volume up/down",
+	"c          Clear the error field",
+	"f          Toggle frame details (--debug-frames)",
+	"s          Toggle received text messages",
+	"A-Z        Switch to reflector module",
+	"PgUp, PgDn Scroll the log",
+}
+
+// tuiMu guards tuiData, tuiChanged, tuiLog, tuiLogOffset, tuiConnected,
+// tuiAlert, tuiShowHelp, tuiShowFrame, tuiShowMessages and drawing to the
+// terminal
+var tuiMu sync.Mutex
+
+// logRing is a fixed size ring buffer of log lines
+type logRing struct {
+	lines []string
+	start int
+	count int
+}
+
+// newLogRing creates a ring buffer holding up to size lines
+func newLogRing(size int) *logRing {
+	return &logRing{lines: make([]string, size)}
+}
+
+// add appends a line, overwriting the oldest one when full
+func (r *logRing) add(line string) {
+	if r.count < len(r.lines) {
+		r.lines[(r.start+r.count)%len(r.lines)] = line
+		r.count++
+		return
+	}
+	r.lines[r.start] = line
+	r.start = (r.start + 1) % len(r.lines)
+}
+
+// get returns the i-th oldest line
+func (r *logRing) get(i int) string {
+	return r.lines[(r.start+i)%len(r.lines)]
+}
+
+// updateTUI updates the TUI field with the given value. It is safe to call
+// from multiple goroutines.
 func updateTUI(field, value string) {
-	switch field {
-	case "StreamID", "FrameNumber", "TYPE":
-		// Convert the value to hexadecimal
-		if intValue, err := strconv.Atoi(value); err == nil {
-			tuiData[field] = fmt.Sprintf("0x%X", intValue)
-		} else {
-			tuiData[field] = value // Fallback to the original value if conversion fails
-		}
-	default:
-		tuiData[field] = value
+	tuiMu.Lock()
+	if isTimestamped(field, value) && value != tuiData[field] {
+		tuiChanged[field] = time.Now()
 	}
+	tuiData[field] = value
+	if (field == "Status" || field == "Error") && value != "" {
+		tuiLog.add(fmt.Sprintf("%s %-6s %s", time.Now().Format("15:04:05"), field, value))
+	}
+	tuiMu.Unlock()
 	drawTUI()
 }
 
@@ -65,37 +170,273 @@ var fieldDisplayNames = map[string]string{
 	"DST":                   "Destination",
 	"SRC":                   "Source",
 	"TYPE":                  "Type",
+	"TypeFlags":             "Type Flags",
 	"META":                  "Metadata",
+	"Position":              "Position",
+	"Text":                  "Text",
+	"Message":               "Message",
+	"Nonce":                 "Nonce",
+	"Encrypted":             "Encryption",
 	"PacketStreamIndicator": "Packet Stream Indicator",
 	"DataTypeIndicator":     "Data Type Indicator",
 	"EncryptionType":        "Encryption Type",
 	"EncryptionSubtype":     "Encryption Subtype",
 	"ChannelAccessNumber":   "Channel Access Number",
+	"CodecMode":             "Codec Mode",
+	"FrameLoss":             "Frame Loss",
+	"FrameRate":             "Frame Rate",
+	"TXDuration":            "TX Duration",
 	"Payload":               "Payload",
 	"Status":                "Status",
+	"Module":                "Module",
+	"Volume":                "Volume",
+	"Level":                 "Level",
+	"LinkHealth":            "Link Health",
+	"RTT":                   "Round Trip",
+	"Squelch":               "Squelch",
+	"Audio":                 "Audio",
+	"Relay":                 "Relay",
+	"CRCFailures":           "CRC Failures",
 	"Error":                 "Error",
 }
 
 // drawTUI draws the TUI
 func drawTUI() {
+	tuiMu.Lock()
+	defer tuiMu.Unlock()
+
+	if !tuiActive {
+		return
+	}
+
 	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
 	tbprint(0, 0, termbox.ColorDefault, termbox.ColorDefault, "M17 Listen Client")
 	tbprint(0, 1, termbox.ColorDefault, termbox.ColorDefault, "") // Blank line
 	y := 2
 	for _, key := range []string{
-		"StreamID", "FrameNumber", "DST", "SRC", "TYPE", "META",
+		"StreamID", "FrameNumber", "TXDuration", "DST", "SRC", "TYPE", "TypeFlags", "META", "Position", "Text", "Message", "Encrypted", "Nonce",
 		"PacketStreamIndicator", "DataTypeIndicator", "EncryptionType",
-		"EncryptionSubtype", "ChannelAccessNumber", "Payload",
-		"Status", "Error",
+		"EncryptionSubtype", "ChannelAccessNumber", "CodecMode", "FrameLoss", "FrameRate", "Payload",
+		"Status", "Relay", "Module", "Volume", "Level", "Audio", "Squelch", "LinkHealth", "RTT", "CRCFailures", "Error",
 	} {
 		displayName := fieldDisplayNames[key]
 		tbprint(0, y, termbox.ColorDefault, termbox.ColorDefault, displayName+":")
-		tbprint(26, y, termbox.ColorDefault, termbox.ColorDefault, tuiData[key])
+		value := tuiData[key]
+		if isTimestamped(key, value) {
+			value = formatTimestamped(value, tuiChanged[key])
+		}
+		tbprint(26, y, tuiFieldColor(key), termbox.ColorDefault, value)
+		y++
+	}
+
+	// Draw the log pane in the remaining space below the fields
+	y++
+	_, height := termbox.Size()
+	rows := height - y - 1
+	if rows > 0 {
+		maxOffset := max(tuiLog.count-rows, 0)
+		tuiLogOffset = min(tuiLogOffset, maxOffset)
+		header := "Log:"
+		if tuiLogOffset > 0 {
+			header = fmt.Sprintf("Log (%d lines back, PgDn to return):", tuiLogOffset)
+		}
+		tbprint(0, y, termbox.ColorDefault, termbox.ColorDefault, header)
 		y++
+		first := max(tuiLog.count-rows-tuiLogOffset, 0)
+		for i := first; i < tuiLog.count-tuiLogOffset; i++ {
+			tbprint(0, y, termbox.ColorDefault, termbox.ColorDefault, tuiLog.get(i))
+			y++
+		}
+	}
+
+	if tuiShowFrame {
+		drawTUIFrame()
+	}
+	if tuiShowMessages {
+		drawTUIMessages()
+	}
+	if tuiShowHelp {
+		drawTUIHelp()
 	}
 	termbox.Flush()
 }
 
+// drawTUIHelp draws the key binding help overlay. The caller must hold tuiMu.
+func drawTUIHelp() {
+	width := 0
+	for _, line := range tuiHelp {
+		width = max(width, len(line))
+	}
+	for i, line := range tuiHelp {
+		row := fmt.Sprintf(" %-*s ", width, line)
+		tbprint(4, 3+i, termbox.ColorBlack, termbox.ColorWhite, row)
+	}
+}
+
+// drawTUIFrame draws the frame details overlay. The caller must hold tuiMu.
+func drawTUIFrame() {
+	lines := []string{"Frame details", ""}
+	if tuiData["FrameDetails"] == "" {
+		lines = append(lines, "No frames yet, frame details need --debug-frames")
+	} else {
+		lines = append(lines, strings.Split(tuiData["FrameDetails"], "\n")...)
+	}
+	width := 0
+	for _, line := range lines {
+		width = max(width, len(line))
+	}
+	for i, line := range lines {
+		row := fmt.Sprintf(" %-*s ", width, line)
+		tbprint(4, 3+i, termbox.ColorBlack, termbox.ColorWhite, row)
+	}
+}
+
+// drawTUIMessages draws the text messages overlay, newest first. The caller
+// must hold tuiMu.
+func drawTUIMessages() {
+	lines := []string{"Text messages", ""}
+	if tuiData["Messages"] == "" {
+		lines = append(lines, "No text messages yet")
+	} else {
+		lines = append(lines, strings.Split(tuiData["Messages"], "\n")...)
+	}
+	width := 0
+	for _, line := range lines {
+		width = max(width, len(line))
+	}
+	for i, line := range lines {
+		row := fmt.Sprintf(" %-*s ", width, line)
+		tbprint(4, 3+i, termbox.ColorBlack, termbox.ColorWhite, row)
+	}
+}
+
+// toggleTUIMessages shows or hides the text messages overlay
+func toggleTUIMessages() {
+	tuiMu.Lock()
+	tuiShowMessages = !tuiShowMessages
+	tuiMu.Unlock()
+	drawTUI()
+}
+
+// toggleTUIFrame shows or hides the frame details overlay
+func toggleTUIFrame() {
+	tuiMu.Lock()
+	tuiShowFrame = !tuiShowFrame
+	tuiMu.Unlock()
+	drawTUI()
+}
+
+// toggleTUIHelp shows or hides the key binding help overlay
+func toggleTUIHelp() {
+	tuiMu.Lock()
+	tuiShowHelp = !tuiShowHelp
+	tuiMu.Unlock()
+	drawTUI()
+}
+
+// tuiFieldColor returns the color of a field value based on the current
+// state. The caller must hold tuiMu.
+func tuiFieldColor(field string) termbox.Attribute {
+	if tuiNoColor {
+		return termbox.ColorDefault
+	}
+	switch field {
+	case "Error":
+		if tuiData[field] != "" && tuiData[field] != "None" {
+			return termbox.ColorRed
+		}
+	case "Status":
+		if tuiAlert {
+			return termbox.ColorYellow
+		}
+		if tuiConnected {
+			return termbox.ColorGreen
+		}
+	case "Encrypted":
+		if tuiData[field] != "" {
+			return termbox.ColorMagenta
+		}
+	case "DST", "SRC":
+		if field == "SRC" && tuiAlert {
+			return termbox.ColorYellow
+		}
+		// Highlight the callsigns while a stream is active
+		if tuiData["StreamID"] != "" {
+			return termbox.ColorCyan
+		}
+	}
+	return termbox.ColorDefault
+}
+
+// setTUIConnected sets whether the relay/reflector connection is up
+func setTUIConnected(connected bool) {
+	tuiMu.Lock()
+	tuiConnected = connected
+	tuiMu.Unlock()
+}
+
+// setTUIAlert sets whether a stream from a watched callsign is active
+func setTUIAlert(alert bool) {
+	tuiMu.Lock()
+	tuiAlert = alert
+	tuiMu.Unlock()
+	drawTUI()
+}
+
+// scrollTUILog scrolls the log pane back by the given number of lines, or
+// forward when negative
+func scrollTUILog(lines int) {
+	tuiMu.Lock()
+	tuiLogOffset = min(max(tuiLogOffset+lines, 0), tuiLog.count)
+	tuiMu.Unlock()
+	drawTUI()
+}
+
+// volumeStep is the volume change per keypress
+const volumeStep = 0.1
+
+// tuiLogPage is the number of log lines scrolled per PgUp/PgDn
+const tuiLogPage = 10
+
+// handleTUIEvents handles key presses in the TUI until the user quits
+func handleTUIEvents(client *Client, quit chan<- struct{}) {
+	for {
+		switch ev := termbox.PollEvent(); ev.Type {
+		case termbox.EventKey:
+			switch {
+			case ev.Key == termbox.KeyCtrlC || ev.Ch == 'q':
+				close(quit)
+				return
+			case ev.Ch == '?':
+				toggleTUIHelp()
+			case ev.Ch == 'c':
+				client.clearError()
+			case ev.Ch == 'f':
+				toggleTUIFrame()
+			case ev.Ch == 's':
+				toggleTUIMessages()
+			case ev.Ch >= 'A' && ev.Ch <= 'Z':
+				if err := client.switchModule(byte(ev.Ch)); err != nil {
+					slog.Error("failed to switch module", "err", err)
+					updateTUI("Error", fmt.Sprintf("failed to switch module: %v", err))
+				}
+			case ev.Ch == '+' || ev.Ch == '=':
+				client.setVolume(client.getVolume() + volumeStep)
+			case ev.Ch == '-':
+				client.setVolume(client.getVolume() - volumeStep)
+			case ev.Ch == 'm':
+				client.toggleMute()
+			case ev.Key == termbox.KeyPgup:
+				scrollTUILog(tuiLogPage)
+			case ev.Key == termbox.KeyPgdn:
+				scrollTUILog(-tuiLogPage)
+			}
+		case termbox.EventError:
+			slog.Error("termbox error", "err", ev.Err)
+		}
+	}
+}
+
 // tbprint prints a message to the TUI at the given coordinates
 func tbprint(x, y int, fg, bg termbox.Attribute, msg string) {
 	for _, c := range msg {
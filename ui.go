@@ -0,0 +1,104 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// errorStaleAfter is how long the GUI shows an unchanged error as current
+// before graying it out
+const errorStaleAfter = time.Minute
+
+// updateField updates a field in the TUI and the GUI, each of which ignores
+// the update when it isn't active, and reports errors as JSON events. It is
+// safe to call from multiple goroutines.
+func updateField(field, value string) {
+	updateTUI(field, value)
+	updateGUI(field, value)
+	if field == "Error" && value != "" {
+		jsonEvents.emit(jsonEvent{Event: EventError, Error: value})
+	}
+}
+
+// isTerminal reports whether f is a terminal the TUI can be drawn on
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// hasDisplay reports whether a GUI can be shown. Only X11 and Wayland
+// desktops need a display set, macOS and Windows always have one.
+func hasDisplay() bool {
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		return true
+	}
+	return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+}
+
+// isTimestamped reports whether a field is shown with the time its value
+// last changed, telling fresh and stale conditions apart
+func isTimestamped(field, value string) bool {
+	return (field == "Status" || field == "Error") && value != "" && value != "None"
+}
+
+// formatTimestamped prefixes a field value with the time it last changed,
+// e.g. "[15:04:05] Connected"
+func formatTimestamped(value string, changed time.Time) string {
+	return fmt.Sprintf("[%s] %s", changed.Format("15:04:05"), value)
+}
+
+// formatHex formats a 16-bit field such as the stream ID, frame number or
+// type for display. It is the one representation used by the TUI, the GUI,
+// the logs and the activity log, e.g. 0x00A5.
+func formatHex(value uint16) string {
+	return fmt.Sprintf("0x%04X", value)
+}
+
+// formatDestination describes the destination callsign of a transmission,
+// telling broadcasts and reflector or module targets apart from transmissions
+// to a single station, e.g. "M17-USA module C (reflector)"
+func formatDestination(dst string) string {
+	switch {
+	case dst == "BROADCAST":
+		return "BROADCAST (all stations)"
+	case strings.HasPrefix(dst, "#"):
+		return dst + " (reflector)"
+	case strings.HasPrefix(dst, "("):
+		// (none) or (reserved)
+		return dst
+	}
+
+	// Reflectors are addressed by designator with the module letter in the
+	// last position, e.g. "M17-USA C"
+	name, module, _ := strings.Cut(dst, " ")
+	module = strings.TrimSpace(module)
+	if strings.HasPrefix(name, "M17-") && len(name) == 7 {
+		if len(module) == 1 {
+			return fmt.Sprintf("%s module %s (reflector)", name, module)
+		}
+		if module == "" {
+			return name + " (reflector)"
+		}
+	}
+	return dst + " (direct)"
+}
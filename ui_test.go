@@ -0,0 +1,62 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "testing"
+
+// TestFormatHex checks 16-bit fields are shown zero padded in uppercase
+func TestFormatHex(t *testing.T) {
+	tests := []struct {
+		value uint16
+		want  string
+	}{
+		{0x0000, "0x0000"},
+		{0x00A5, "0x00A5"},
+		{0x0FFF, "0x0FFF"},
+		{0x1234, "0x1234"},
+		{0xBEEF, "0xBEEF"},
+		{0x8005, "0x8005"},
+		{0xFFFF, "0xFFFF"},
+	}
+	for _, tt := range tests {
+		if got := formatHex(tt.value); got != tt.want {
+			t.Errorf("formatHex(%d) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+// TestFormatDestination checks the description of each kind of destination
+func TestFormatDestination(t *testing.T) {
+	tests := []struct {
+		dst  string
+		want string
+	}{
+		{"(none)", "(none)"},
+		{"(reserved)", "(reserved)"},
+		{"BROADCAST", "BROADCAST (all stations)"},
+		{"#M17-USA", "#M17-USA (reflector)"},
+		{"M17-USA C", "M17-USA module C (reflector)"},
+		{"M17-USA", "M17-USA (reflector)"},
+		{"KC1AWV", "KC1AWV (direct)"},
+	}
+	for _, tt := range tests {
+		if got := formatDestination(tt.dst); got != tt.want {
+			t.Errorf("formatDestination(%q) = %q, want %q", tt.dst, got, tt.want)
+		}
+	}
+}
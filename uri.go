@@ -0,0 +1,81 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"go-m17-listen/m17"
+	"net/url"
+	"strings"
+)
+
+// m17URIScheme is the scheme of connect links such as
+// m17://ref.example.org:17000/C?callsign=N0CALL
+const m17URIScheme = "m17"
+
+// connectURI is a parsed connect link
+type connectURI struct {
+	Address  string // host:port, or a reflector designator without a port
+	Module   byte   // Module letter, a space when none is given
+	Callsign string // Listener callsign, empty when none is given
+}
+
+// isConnectURI reports whether an argument is a connect link rather than an
+// address
+func isConnectURI(arg string) bool {
+	return strings.HasPrefix(strings.ToLower(arg), m17URIScheme+"://")
+}
+
+// parseConnectURI parses a connect link of the form
+// m17://host[:port][/MODULE][?callsign=CALLSIGN], e.g.
+// m17://M17-USA/C or m17://[2001:db8::1]:17000/A?callsign=N0CALL
+func parseConnectURI(s string) (connectURI, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return connectURI{}, err
+	}
+	if !strings.EqualFold(u.Scheme, m17URIScheme) {
+		return connectURI{}, fmt.Errorf("unsupported scheme %q, expected %s://", u.Scheme, m17URIScheme)
+	}
+	if u.Host == "" {
+		return connectURI{}, fmt.Errorf("missing host")
+	}
+	if u.User != nil || u.Fragment != "" {
+		return connectURI{}, fmt.Errorf("unexpected user info or fragment")
+	}
+
+	uri := connectURI{Address: u.Host}
+	uri.Module, err = parseModule(strings.Trim(u.Path, "/"))
+	if err != nil {
+		return connectURI{}, fmt.Errorf("invalid module: %w", err)
+	}
+
+	query := u.Query()
+	for key := range query {
+		if key != "callsign" {
+			return connectURI{}, fmt.Errorf("unknown parameter %q", key)
+		}
+	}
+	if callsign := query.Get("callsign"); callsign != "" {
+		uri.Callsign = strings.ToUpper(callsign)
+		if _, err := m17.EncodeCallsign(uri.Callsign); err != nil {
+			return connectURI{}, fmt.Errorf("invalid callsign: %w", err)
+		}
+	}
+	return uri, nil
+}
@@ -20,66 +20,32 @@ package main
 import (
 	"fmt"
 	"math/rand"
+	"net"
+	"strings"
 	"time"
 )
 
-// base40Chars is the character set used for encoding callsigns
-const (
-	base40Chars = " ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-/."
-)
-
-// encodeCallsign encodes a callsign into a 6-byte address
-func encodeCallsign(callsign string) ([]byte, error) {
-	address := uint64(0)
-
-	for i := len(callsign) - 1; i >= 0; i-- {
-		c := callsign[i]
-		val := 0
-		switch {
-		case c == ' ':
-			val = 0
-		case 'A' <= c && c <= 'Z':
-			val = int(c-'A') + 1
-		case '0' <= c && c <= '9':
-			val = int(c-'0') + 27
-		case c == '-':
-			val = 37
-		case c == '/':
-			val = 38
-		case c == '.':
-			val = 39
-		default:
-			return nil, fmt.Errorf("invalid character in callsign: %c", c)
-		}
-
-		address = address*40 + uint64(val)
+// parseModule parses a reflector module letter, accepting lowercase letters.
+// An empty or blank module selects no module, as used with relays.
+func parseModule(s string) (byte, error) {
+	module := strings.ToUpper(strings.TrimSpace(s))
+	if module == "" {
+		return ' ', nil
 	}
-
-	result := make([]byte, 6)
-	for i := 5; i >= 0; i-- {
-		result[i] = byte(address & 0xFF)
-		address >>= 8
+	if len(module) != 1 || module[0] < 'A' || module[0] > 'Z' {
+		return 0, fmt.Errorf("%q is not a single letter A-Z", s)
 	}
-
-	return result, nil
+	return module[0], nil
 }
 
-// decodeCallsign decodes a 6-byte address into a callsign
-func decodeCallsign(encoded []byte) string {
-	address := uint64(0)
-
-	for _, b := range encoded {
-		address = address*256 + uint64(b)
+// sameUDPAddr reports whether two UDP addresses refer to the same endpoint,
+// treating IPv4-mapped IPv6 addresses as their IPv4 equivalent
+func sameUDPAddr(a, b *net.UDPAddr) bool {
+	if a == nil || b == nil {
+		return false
 	}
-
-	callsign := ""
-	for address > 0 {
-		idx := address % 40
-		callsign += string(base40Chars[idx])
-		address /= 40
-	}
-
-	return callsign
+	ap, bp := a.AddrPort(), b.AddrPort()
+	return ap.Addr().Unmap().WithZone("") == bp.Addr().Unmap().WithZone("") && ap.Port() == bp.Port()
 }
 
 // generateRandomCallsign generates a random callsign
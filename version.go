@@ -0,0 +1,66 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"go-m17-listen/codec2"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Build information, set at build time with e.g.
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// versionInfo describes the build for --version and bug reports. The commit
+// and date fall back to the VCS information Go embeds when they aren't set
+// with -ldflags.
+func versionInfo() string {
+	rev, date := commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && rev == "":
+				rev = setting.Value
+			case setting.Key == "vcs.time" && date == "":
+				date = setting.Value
+			}
+		}
+	}
+	if rev == "" {
+		rev = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+
+	bitrates := make([]string, len(codec2.Bitrates))
+	for i, bitrate := range codec2.Bitrates {
+		bitrates[i] = fmt.Sprint(bitrate)
+	}
+
+	return fmt.Sprintf("go-m17-listen %s\ncommit: %s\nbuilt: %s\ngo: %s %s/%s\ncodec2 modes: %s bps\n",
+		version, rev, date, runtime.Version(), runtime.GOOS, runtime.GOARCH, strings.Join(bitrates, ", "))
+}
@@ -0,0 +1,100 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"go-m17-listen/m17"
+	"os"
+	"strings"
+)
+
+// watchList is a list of callsign patterns to alert on. A * in a pattern
+// matches any number of characters, e.g. KC1* matches KC1AWV.
+type watchList []string
+
+// parseWatchList parses a comma-separated list of callsign patterns, or the
+// path of a file listing them one or more per line. Blank lines and lines
+// starting with # are ignored in files.
+func parseWatchList(spec string) (watchList, error) {
+	if data, err := os.ReadFile(spec); err == nil {
+		var lines []string
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				lines = append(lines, line)
+			}
+		}
+		spec = strings.Join(lines, ",")
+	}
+
+	var list watchList
+	for _, pattern := range strings.Split(spec, ",") {
+		pattern = strings.ToUpper(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if chars := strings.ReplaceAll(pattern, "*", ""); chars != "" {
+			if _, err := m17.EncodeCallsign(chars); err != nil {
+				return nil, fmt.Errorf("invalid watch pattern %q: %w", pattern, err)
+			}
+		}
+		list = append(list, pattern)
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("no callsigns in watch list")
+	}
+
+	return list, nil
+}
+
+// matches reports whether the callsign matches any pattern in the list
+func (w watchList) matches(callsign string) bool {
+	callsign = strings.ToUpper(strings.TrimSpace(callsign))
+	for _, pattern := range w {
+		if matchWildcard(pattern, callsign) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchWildcard reports whether s matches pattern, where * matches any
+// number of characters
+func matchWildcard(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+
+	// The first part must be a prefix and the last a suffix, the parts in
+	// between must appear in order
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return len(s) >= len(last) && strings.HasSuffix(s, last)
+}
@@ -0,0 +1,392 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"syscall"
+	"time"
+)
+
+// linkHealthTimeout is how long without a PING before the link is considered
+// unhealthy
+const linkHealthTimeout = 30 * time.Second
+
+// Write retry settings, a write failing with a temporary error such as
+// ENOBUFS is retried up to writeRetries times, writeRetryDelay apart
+const (
+	writeRetries    = 3
+	writeRetryDelay = 50 * time.Millisecond
+)
+
+// Connection states shown in the status field
+const (
+	StateConnecting   = "Connecting"
+	StateAwaitingACKN = "Waiting for ACKN"
+	StateListening    = "Listening"
+	StateReconnecting = "Reconnecting"
+	StateTimedOut     = "Timed out"
+)
+
+// connection returns the current UDP connection to the relay/reflector
+func (c *Client) connection() *net.UDPConn {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	return c.conn
+}
+
+// relay returns the current address of the relay/reflector
+func (c *Client) relay() *net.UDPAddr {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	return c.relayAddr
+}
+
+// host returns the address of the relay/reflector being used, as given
+func (c *Client) host() string {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	return c.relayHost
+}
+
+// failover switches to the next relay/reflector address, wrapping around to
+// the first one after the last. It reports whether there is another address
+// to switch to. The caller redials and re-sends LSTN.
+func (c *Client) failover() bool {
+	if len(c.relayHosts) < 2 {
+		return false
+	}
+
+	c.connMu.Lock()
+	previous := c.relayHost
+	c.relayIndex = (c.relayIndex + 1) % len(c.relayHosts)
+	c.relayHost = c.relayHosts[c.relayIndex]
+	next := c.relayHost
+	c.connMu.Unlock()
+
+	c.failedOver.Store(true)
+	slog.Warn("Failing over to the next relay/reflector", "from", previous, "to", next)
+	updateField("Relay", next)
+	return true
+}
+
+// write sends a packet to the relay/reflector, retrying temporary failures
+// so a brief network hiccup doesn't drop a keepalive
+func (c *Client) write(packet []byte) error {
+	conn := c.connection()
+	if conn == nil {
+		return fmt.Errorf("not connected")
+	}
+	var err error
+	for attempt := 0; attempt <= writeRetries; attempt++ {
+		if attempt > 0 {
+			slog.Debug("Retrying write", "attempt", attempt, "err", err)
+			time.Sleep(writeRetryDelay)
+		}
+		if _, err = conn.Write(packet); err == nil || !isTemporary(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// isTemporary reports whether a write error is likely to clear up on its
+// own, such as a full socket buffer or a timeout
+func isTemporary(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.ENOMEM)
+}
+
+// touch records that a packet was received from the relay/reflector
+func (c *Client) touch() {
+	c.connMu.Lock()
+	c.lastRx = time.Now()
+	recovered := c.state == StateReconnecting || c.state == StateTimedOut
+	c.connMu.Unlock()
+
+	if recovered {
+		c.setState(StateListening)
+	}
+}
+
+// touchKeepalive records that a PING or PONG keepalive was received from
+// the relay/reflector
+func (c *Client) touchKeepalive(magic string) {
+	c.connMu.Lock()
+	c.lastPingTime = time.Now()
+	c.keepalive = magic
+	c.connMu.Unlock()
+}
+
+// monitorLinkHealth periodically updates the link health field with the time
+// since the last PING, warning when PINGs stop arriving
+func (c *Client) monitorLinkHealth() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	healthy := true
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		c.connMu.Lock()
+		elapsed := time.Since(c.lastPingTime).Round(time.Second)
+		keepalive := c.keepalive
+		c.connMu.Unlock()
+
+		health := fmt.Sprintf("OK (%s since last %s)", elapsed, keepalive)
+		if elapsed >= linkHealthTimeout {
+			health = fmt.Sprintf("Unhealthy (%s since last %s)", elapsed, keepalive)
+			if healthy {
+				slog.Warn("no PING or PONG received", "elapsed", elapsed)
+			}
+			healthy = false
+		} else {
+			healthy = true
+		}
+		updateField("LinkHealth", health)
+	}
+}
+
+// setState updates the connection state shown in the status field
+func (c *Client) setState(state string) {
+	c.connMu.Lock()
+	c.state = state
+	c.connMu.Unlock()
+
+	slog.Info("Connection state", "state", state)
+	setTUIConnected(state == StateListening)
+	updateField("Status", state)
+}
+
+// watchdog monitors traffic from the relay/reflector and reconnects when
+// nothing has been received within the keepalive timeout. The first missed
+// timeout re-sends LSTN, later ones fail over to the next address if there is
+// one, re-resolve the address and re-dial.
+func (c *Client) watchdog(timeout time.Duration) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	attempts := 0
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		c.connMu.Lock()
+		elapsed := time.Since(c.lastRx)
+		c.connMu.Unlock()
+
+		// Traffic resumed, start counting again
+		if elapsed < timeout {
+			attempts = 0
+			continue
+		}
+		if elapsed < time.Duration(attempts+1)*timeout {
+			continue
+		}
+		attempts++
+
+		slog.Warn("no packets from relay/reflector", "elapsed", elapsed.Round(time.Second))
+		c.setState(StateReconnecting)
+		if attempts > 1 {
+			c.failover()
+			if err := c.redial(); err != nil {
+				slog.Error("failed to reconnect", "err", err)
+				updateField("Error", fmt.Sprintf("failed to reconnect: %v", err))
+				c.setState(StateTimedOut)
+				continue
+			}
+		}
+		if err := c.sendLSTN(); err != nil {
+			slog.Error("failed to send LSTN packet", "err", err)
+			updateField("Error", fmt.Sprintf("failed to send LSTN packet: %v", err))
+			c.setState(StateTimedOut)
+		}
+	}
+}
+
+// redial re-resolves the relay/reflector address and replaces the UDP
+// connection. The listen loop picks up the new connection once the old one
+// is closed.
+func (c *Client) redial() error {
+	addr, err := net.ResolveUDPAddr(c.network, c.host())
+	if err != nil {
+		return fmt.Errorf("failed to resolve address: %w", err)
+	}
+
+	// A fixed local port can only be bound once, so release it before
+	// dialing again
+	if old := c.connection(); old != nil && c.fixedLocalPort() {
+		old.Close()
+	}
+	conn, err := net.DialUDP(c.network, c.localAddr, addr)
+	if err != nil {
+		return fmt.Errorf("failed to dial: %w", err)
+	}
+
+	// Don't replace the connection while shutting down
+	if c.ctx.Err() != nil {
+		conn.Close()
+		return c.ctx.Err()
+	}
+
+	c.connMu.Lock()
+	old := c.conn
+	c.conn = conn
+	c.relayAddr = addr
+	c.connMu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// Subscription settings, LSTN is re-sent when no ACKN or NACK arrives within
+// acknTimeout and the client gives up after lstnAttempts
+const (
+	acknTimeout  = 5 * time.Second
+	lstnAttempts = 3
+)
+
+// awaitACKN waits for the relay/reflector to accept the subscription,
+// re-sending LSTN when it doesn't answer and failing over to the next address
+// after lstnAttempts. A NACK stops the client in handleNACK.
+func (c *Client) awaitACKN() error {
+	failovers := 0
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		case <-c.ackn:
+			return nil
+		case <-time.After(acknTimeout):
+		}
+		if attempt >= lstnAttempts {
+			if failovers+1 >= len(c.relayHosts) || !c.failover() {
+				return fmt.Errorf("no ACKN from relay/reflector after %d attempts", attempt)
+			}
+			failovers++
+			attempt = 0
+			if err := c.redial(); err != nil {
+				return err
+			}
+			if err := c.sendLSTN(); err != nil {
+				return fmt.Errorf("failed to send LSTN packet: %w", err)
+			}
+			continue
+		}
+
+		slog.Warn("no ACKN from relay/reflector, re-sending LSTN")
+		if err := c.sendLSTN(); err != nil {
+			return fmt.Errorf("failed to send LSTN packet: %w", err)
+		}
+	}
+}
+
+// resubscribe re-sends LSTN with exponential backoff after the
+// relay/reflector dropped the subscription, until it answers with ACKN or
+// the client shuts down. A DISC received while it is already re-subscribing
+// doesn't start another loop.
+func (c *Client) resubscribe() {
+	if !c.reconnecting.CompareAndSwap(false, true) {
+		return
+	}
+	defer c.reconnecting.Store(false)
+
+	// Forget ACKNs left over from earlier subscriptions
+	select {
+	case <-c.ackn:
+	default:
+	}
+
+	c.setState(StateReconnecting)
+	backoff := connectMinBackoff
+	for {
+		if err := c.sendLSTN(); err != nil {
+			slog.Error("failed to send LSTN packet", "err", err)
+			updateField("Error", fmt.Sprintf("failed to send LSTN packet: %v", err))
+		}
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-c.ackn:
+			slog.Info("Re-subscribed to relay/reflector")
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, connectMaxBackoff)
+	}
+}
+
+// fixedLocalPort reports whether the connection is bound to a fixed local
+// port with --local-addr
+func (c *Client) fixedLocalPort() bool {
+	return c.localAddr != nil && c.localAddr.Port != 0
+}
+
+// Startup connection backoff limits
+const (
+	connectMinBackoff = time.Second
+	connectMaxBackoff = 30 * time.Second
+)
+
+// connect dials the relay/reflector and sends LSTN, retrying with exponential
+// backoff when the network isn't up yet and trying the fallback addresses in
+// turn. It gives up after the configured number of attempts, or never when
+// that is 0.
+func (c *Client) connect() error {
+	backoff := connectMinBackoff
+	for attempt := 1; ; attempt++ {
+		c.setState(fmt.Sprintf("%s (attempt %d)", StateConnecting, attempt))
+		err := c.redial()
+		if err == nil {
+			err = c.sendLSTN()
+		}
+		if err == nil {
+			c.setState(StateAwaitingACKN)
+			return nil
+		}
+		if c.attempts > 0 && attempt >= c.attempts {
+			return fmt.Errorf("failed to connect after %d attempts: %w", attempt, err)
+		}
+
+		c.failover()
+		slog.Warn("failed to connect, retrying", "backoff", backoff, "err", err)
+		updateField("Error", fmt.Sprintf("failed to connect, retrying in %s: %v", backoff, err))
+		select {
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, connectMaxBackoff)
+	}
+}
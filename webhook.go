@@ -0,0 +1,172 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Webhook event types
+const (
+	WebhookStreamStart = "stream_start"
+	WebhookStreamEnd   = "stream_end"
+	WebhookWatchHeard  = "watch_heard"
+	WebhookTalkTime    = "talk_time_exceeded"
+)
+
+// Webhook delivery settings
+const (
+	webhookQueueSize    = 32
+	webhookTimeout      = 5 * time.Second
+	webhookFlushTimeout = 2 * time.Second
+)
+
+// webhookEvent is the JSON payload posted to the webhook
+type webhookEvent struct {
+	Event     string    `json:"event"`
+	SRC       string    `json:"src"`
+	DST       string    `json:"dst"`
+	StreamID  uint16    `json:"stream_id"`
+	Module    string    `json:"module"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// webhook posts events to a URL from a background goroutine so slow
+// deliveries never stall decoding. A nil *webhook discards events.
+type webhook struct {
+	url     string
+	queue   chan webhookEvent
+	client  *http.Client
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stop    chan struct{}
+	done    chan struct{}
+	started bool
+}
+
+// newWebhook creates a webhook posting to url
+func newWebhook(url string) *webhook {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &webhook{
+		url:    url,
+		queue:  make(chan webhookEvent, webhookQueueSize),
+		client: &http.Client{Timeout: webhookTimeout},
+		ctx:    ctx,
+		cancel: cancel,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// send queues an event, dropping it if the queue is full
+func (w *webhook) send(event webhookEvent) {
+	if w == nil {
+		return
+	}
+	select {
+	case w.queue <- event:
+	default:
+		slog.Warn("webhook queue full, dropping event", "event", event.Event)
+	}
+}
+
+// start delivers queued events from a background goroutine until close is
+// called
+func (w *webhook) start() {
+	w.started = true
+	go w.run()
+}
+
+// run delivers queued events until close is called, then delivers the ones
+// still queued
+func (w *webhook) run() {
+	defer close(w.done)
+	for {
+		select {
+		case <-w.stop:
+			for {
+				select {
+				case event := <-w.queue:
+					if w.ctx.Err() != nil {
+						return
+					}
+					w.deliver(event)
+				default:
+					return
+				}
+			}
+		case event := <-w.queue:
+			w.deliver(event)
+		}
+	}
+}
+
+// close stops delivering events once the queued ones, such as the stream_end
+// of a stream finished at shutdown, are delivered or webhookFlushTimeout
+// passes. Events sent after close are never delivered.
+func (w *webhook) close() {
+	if w == nil || !w.started {
+		return
+	}
+	close(w.stop)
+	select {
+	case <-w.done:
+	case <-time.After(webhookFlushTimeout):
+		slog.Warn("timed out delivering queued webhook events", "dropped", len(w.queue))
+		w.cancel()
+		<-w.done
+	}
+	w.cancel()
+}
+
+// deliver posts an event, reporting a failure
+func (w *webhook) deliver(event webhookEvent) {
+	if err := w.post(w.ctx, event); err != nil {
+		slog.Error("failed to post webhook", "err", err)
+		updateField("Error", fmt.Sprintf("failed to post webhook: %v", err))
+	}
+}
+
+// post delivers a single event
+func (w *webhook) post(ctx context.Context, event webhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
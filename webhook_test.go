@@ -0,0 +1,91 @@
+/*
+Copyright (C) 2024 Steve Miller KC1AWV
+
+This program is free software: you can redistribute it and/or modify it
+under the terms of the GNU General Public License as published by the Free
+Software Foundation, either version 3 of the License, or (at your option)
+any later version.
+
+This program is distributed in the hope that it will be useful, but WITHOUT
+ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+FITNESS FOR A PARTICULAR PURPOSE. See the GNU General Public License for
+more details.
+
+You should have received a copy of the GNU General Public License along with
+this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestWebhookCloseDeliversQueued checks events still queued at shutdown,
+// like the stream_end of the stream finished by Close, are delivered
+func TestWebhookCloseDeliversQueued(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event webhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("bad webhook body: %v", err)
+		}
+		mu.Lock()
+		events = append(events, event.Event)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	w := newWebhook(server.URL)
+	w.start()
+	w.send(webhookEvent{Event: WebhookStreamStart})
+	w.send(webhookEvent{Event: WebhookStreamEnd})
+	w.close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 2 || events[0] != WebhookStreamStart || events[1] != WebhookStreamEnd {
+		t.Errorf("delivered %v, want [%s %s]", events, WebhookStreamStart, WebhookStreamEnd)
+	}
+}
+
+// TestWebhookCloseTimeout checks close gives up on a webhook that doesn't
+// answer after webhookFlushTimeout
+func TestWebhookCloseTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	w := newWebhook(server.URL)
+	w.start()
+	w.send(webhookEvent{Event: WebhookStreamEnd})
+
+	start := time.Now()
+	w.close()
+	if elapsed := time.Since(start); elapsed > webhookFlushTimeout+time.Second {
+		t.Errorf("close took %s, want about %s", elapsed, webhookFlushTimeout)
+	}
+}
+
+// TestWebhookCloseNotStarted checks close returns at once when events were
+// never delivered, e.g. when replaying a capture
+func TestWebhookCloseNotStarted(t *testing.T) {
+	w := newWebhook("http://127.0.0.1:0")
+	w.send(webhookEvent{Event: WebhookStreamEnd})
+	w.close()
+
+	var nilWebhook *webhook
+	nilWebhook.close()
+}